@@ -0,0 +1,158 @@
+package circuit
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/uints"
+	gnarkNimue "github.com/reilabs/gnark-nimue"
+)
+
+// How the challenges of a WHIR proof are derived from the transcript, see
+// ChallengeReducer.
+const (
+	// nativeChallengeReduction squeezes native field elements from the sponge.
+	// This is the default.
+	nativeChallengeReduction = "native"
+	// modularChallengeReduction squeezes digest bytes and reduces them with
+	// ModularReduction.
+	modularChallengeReduction = "modular"
+	// rejectionChallengeReduction squeezes digest bytes and reduces them with
+	// RejectionSampling.
+	rejectionChallengeReduction = "rejection"
+)
+
+// rejectionSamplingWindows is the number of candidate windows RejectionSampling
+// reads per challenge. A uniformly random window of FieldBitLen bits lies below the
+// BN254 modulus with probability about 3/4, so an honest digest has every window
+// rejected with probability below 2^-32.
+const rejectionSamplingWindows = 16
+
+// ChallengeReducer maps the digest bytes squeezed from the transcript to a field
+// challenge. Provers differ in how they do this, and a verifier that reduces the
+// digest differently from the prover derives entirely different challenges.
+type ChallengeReducer interface {
+	// DigestLength is the number of bytes squeezed for each challenge.
+	DigestLength(api frontend.API) int
+	// Reduce maps a digest of DigestLength bytes to a field element.
+	Reduce(api frontend.API, digest []uints.U8) (frontend.Variable, error)
+}
+
+// ModularReduction reduces the big-endian value of (FieldBitLen+128)/8 digest
+// bytes modulo the field. The 128 extra bits keep the challenge statistically
+// close to uniform.
+type ModularReduction struct{}
+
+func (ModularReduction) DigestLength(api frontend.API) int {
+	return (api.Compiler().FieldBitLen() + 128) / 8
+}
+
+func (r ModularReduction) Reduce(api frontend.API, digest []uints.U8) (frontend.Variable, error) {
+	if len(digest) != r.DigestLength(api) {
+		return nil, fmt.Errorf("modular reduction needs %d digest bytes, got %d", r.DigestLength(api), len(digest))
+	}
+	value := frontend.Variable(0)
+	for _, b := range digest {
+		value = api.Add(b.Val, api.Mul(value, 256))
+	}
+	return value, nil
+}
+
+// RejectionSampling splits the digest into rejectionSamplingWindows windows of
+// (FieldBitLen+7)/8 bytes, masks the big-endian value of each to its low
+// FieldBitLen bits, and takes the first window whose value lies below the modulus.
+// The prover must squeeze every window, including those after the accepted one, so
+// both sides read the same number of bytes from the transcript. A digest with every
+// window rejected does not satisfy the circuit.
+type RejectionSampling struct{}
+
+func (RejectionSampling) DigestLength(api frontend.API) int {
+	return rejectionSamplingWindows * ((api.Compiler().FieldBitLen() + 7) / 8)
+}
+
+func (r RejectionSampling) Reduce(api frontend.API, digest []uints.U8) (frontend.Variable, error) {
+	if len(digest) != r.DigestLength(api) {
+		return nil, fmt.Errorf("rejection sampling needs %d digest bytes, got %d", r.DigestLength(api), len(digest))
+	}
+	fieldBits := api.Compiler().FieldBitLen()
+	windowSize := len(digest) / rejectionSamplingWindows
+
+	challenge := frontend.Variable(0)
+	// pending is 1 until a window has been accepted.
+	pending := frontend.Variable(1)
+	for w := range rejectionSamplingWindows {
+		window := digest[w*windowSize : (w+1)*windowSize]
+		valueBits := make([]frontend.Variable, 0, 8*windowSize)
+		for i := len(window) - 1; i >= 0; i-- {
+			valueBits = append(valueBits, api.ToBinary(window[i].Val, 8)...)
+		}
+		valueBits = valueBits[:fieldBits]
+
+		take := api.Mul(pending, isLessThanConstant(api, valueBits, api.Compiler().Field()))
+		challenge = api.Add(challenge, api.Mul(take, api.FromBinary(valueBits...)))
+		pending = api.Sub(pending, take)
+	}
+	api.AssertIsEqual(pending, 0)
+	return challenge, nil
+}
+
+// isLessThanConstant returns 1 if the value of the little-endian bits is below
+// bound, and 0 otherwise. bound must fit in len(bits) bits.
+func isLessThanConstant(api frontend.API, bits []frontend.Variable, bound *big.Int) frontend.Variable {
+	less, equal := frontend.Variable(0), frontend.Variable(1)
+	for i := len(bits) - 1; i >= 0; i-- {
+		if bound.Bit(i) == 1 {
+			less = api.Add(less, api.Mul(equal, api.Sub(1, bits[i])))
+			equal = api.Mul(equal, bits[i])
+		} else {
+			equal = api.Mul(equal, api.Sub(1, bits[i]))
+		}
+	}
+	return less
+}
+
+// challengeReducer returns the ChallengeReducer the challenge_reduction method
+// selects, or nil for native challenges.
+func challengeReducer(method string) (ChallengeReducer, error) {
+	switch method {
+	case "", nativeChallengeReduction:
+		return nil, nil
+	case modularChallengeReduction:
+		return ModularReduction{}, nil
+	case rejectionChallengeReduction:
+		return RejectionSampling{}, nil
+	default:
+		return nil, fmt.Errorf("unknown challenge reduction %q", method)
+	}
+}
+
+// squeezeChallenges fills out with challenges derived using the given
+// challenge_reduction method.
+func squeezeChallenges(api frontend.API, arthur gnarkNimue.Arthur, method string, out []frontend.Variable) error {
+	reducer, err := challengeReducer(method)
+	if err != nil {
+		return err
+	}
+	return squeezeReduced(api, arthur, reducer, out)
+}
+
+// squeezeReduced fills out with challenges, each reduced from its own digest by
+// reducer, or squeezed as native field elements if reducer is nil.
+func squeezeReduced(api frontend.API, arthur gnarkNimue.Arthur, reducer ChallengeReducer, out []frontend.Variable) error {
+	if reducer == nil {
+		return arthur.FillChallengeScalars(out)
+	}
+	digest := make([]uints.U8, reducer.DigestLength(api))
+	for i := range out {
+		if err := arthur.FillChallengeBytes(digest); err != nil {
+			return err
+		}
+		value, err := reducer.Reduce(api, digest)
+		if err != nil {
+			return err
+		}
+		out[i] = value
+	}
+	return nil
+}
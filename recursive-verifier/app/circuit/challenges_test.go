@@ -0,0 +1,272 @@
+package circuit
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/test"
+	gnarkNimue "github.com/reilabs/gnark-nimue"
+	skyscraper "github.com/reilabs/gnark-skyscraper"
+)
+
+// modularReductionNative is ModularReduction computed out of circuit.
+func modularReductionNative(digest []byte) *big.Int {
+	return new(big.Int).Mod(new(big.Int).SetBytes(digest), ecc.BN254.ScalarField())
+}
+
+// rejectionSamplingNative is RejectionSampling computed out of circuit. It reports
+// false if every window is rejected.
+func rejectionSamplingNative(digest []byte) (*big.Int, bool) {
+	modulus := ecc.BN254.ScalarField()
+	mask := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(modulus.BitLen())), big.NewInt(1))
+	windowSize := len(digest) / rejectionSamplingWindows
+	for w := range rejectionSamplingWindows {
+		value := new(big.Int).SetBytes(digest[w*windowSize : (w+1)*windowSize])
+		value.And(value, mask)
+		if value.Cmp(modulus) < 0 {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func patternBytes(n int, seed int) []byte {
+	digest := make([]byte, n)
+	for i := range digest {
+		digest[i] = byte(i*37 + seed)
+	}
+	return digest
+}
+
+func toU8s(bytes []byte) []uints.U8 {
+	out := make([]uints.U8, len(bytes))
+	for i, b := range bytes {
+		out[i] = uints.NewU8(b)
+	}
+	return out
+}
+
+// reducerCircuit reduces Digest with the reducer Method selects.
+type reducerCircuit struct {
+	Method   string
+	Digest   []uints.U8
+	Expected frontend.Variable
+}
+
+func (c *reducerCircuit) Define(api frontend.API) error {
+	reducer, err := challengeReducer(c.Method)
+	if err != nil {
+		return err
+	}
+	value, err := reducer.Reduce(api, c.Digest)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(value, c.Expected)
+	return nil
+}
+
+func TestModularReduction(t *testing.T) {
+	// 254-bit field, so (254+128)/8 bytes.
+	digest := patternBytes(47, 11)
+	shape := &reducerCircuit{Method: modularChallengeReduction, Digest: make([]uints.U8, 47)}
+	honest := &reducerCircuit{Method: modularChallengeReduction, Digest: toU8s(digest), Expected: modularReductionNative(digest)}
+	if err := test.IsSolved(shape, honest, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("modular reduction rejected: %v", err)
+	}
+
+	tampered := *honest
+	tampered.Expected = new(big.Int).Add(modularReductionNative(digest), big.NewInt(1))
+	if err := test.IsSolved(shape, &tampered, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("wrong modular reduction accepted")
+	}
+
+	short := &reducerCircuit{Method: modularChallengeReduction, Digest: toU8s(digest[:32]), Expected: 0}
+	if err := test.IsSolved(&reducerCircuit{Method: modularChallengeReduction, Digest: make([]uints.U8, 32)}, short, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("short digest accepted")
+	}
+}
+
+func TestRejectionSampling(t *testing.T) {
+	const windowSize = 32
+	digest := patternBytes(rejectionSamplingWindows*windowSize, 5)
+	// The first window masks to 2^254 - 1, which is above the modulus.
+	for i := range windowSize {
+		digest[i] = 0xff
+	}
+	// The second one lies below it.
+	digest[windowSize] = 0x10
+	accepted := new(big.Int).SetBytes(digest[windowSize : 2*windowSize])
+	if value, ok := rejectionSamplingNative(digest); !ok || value.Cmp(accepted) != 0 {
+		t.Fatalf("native reference takes %v, expected the second window", value)
+	}
+
+	shape := &reducerCircuit{Method: rejectionChallengeReduction, Digest: make([]uints.U8, len(digest))}
+	honest := &reducerCircuit{Method: rejectionChallengeReduction, Digest: toU8s(digest), Expected: accepted}
+	if err := test.IsSolved(shape, honest, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("rejection sampling rejected: %v", err)
+	}
+
+	rejectedWindow := new(big.Int).Lsh(big.NewInt(1), 254)
+	rejectedWindow.Sub(rejectedWindow, big.NewInt(1))
+	for name, expected := range map[string]*big.Int{
+		"the rejected window reduced":    rejectedWindow.Mod(rejectedWindow, ecc.BN254.ScalarField()),
+		"a later window":                 new(big.Int).SetBytes(digest[2*windowSize : 3*windowSize]),
+		"the modular reduction instead":  modularReductionNative(digest[:47]),
+		"the accepted window off by one": new(big.Int).Add(accepted, big.NewInt(1)),
+	} {
+		tampered := &reducerCircuit{Method: rejectionChallengeReduction, Digest: toU8s(digest), Expected: expected}
+		if err := test.IsSolved(shape, tampered, ecc.BN254.ScalarField()); err == nil {
+			t.Errorf("rejection sampling accepted %s", name)
+		}
+	}
+
+	rejected := make([]byte, len(digest))
+	for i := range rejected {
+		rejected[i] = 0xff
+	}
+	if err := test.IsSolved(shape, &reducerCircuit{Method: rejectionChallengeReduction, Digest: toU8s(rejected), Expected: 0}, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("digest with every window rejected accepted")
+	}
+}
+
+// challengeProbeIO absorbs one scalar and then squeezes enough scalars for two
+// challenges under any reduction: 2 * ceil(512 / 15) for rejection sampling, whose
+// digests are the longest.
+var challengeProbeIO = []byte("whir\x00A1seed\x00S70combination_randomness\x00")
+
+const challengeProbeScalars = 70
+
+// seedTranscript encodes seed as the 32-byte little-endian scalar the probe IO
+// absorbs.
+func seedTranscript(seed int64) []uints.U8 {
+	word := big.NewInt(seed).FillBytes(make([]byte, 32))
+	transcript := make([]uints.U8, len(word))
+	for i := range word {
+		transcript[i] = uints.NewU8(word[len(word)-1-i])
+	}
+	return transcript
+}
+
+// squeezeProbeCircuit squeezes Count scalars after absorbing Transcript and copies
+// them to scalars through a hint, so a test can compute what the circuit should
+// derive from them out of circuit.
+type squeezeProbeCircuit struct {
+	IO         []byte
+	Count      int
+	Transcript []uints.U8
+	scalars    *[]*big.Int
+}
+
+func (c *squeezeProbeCircuit) Define(api frontend.API) error {
+	arthur, err := gnarkNimue.NewSkyscraperArthur(api, skyscraper.NewSkyscraper(api, 2), c.IO, c.Transcript, true)
+	if err != nil {
+		return err
+	}
+	if err := arthur.FillNextScalars(make([]frontend.Variable, 1)); err != nil {
+		return err
+	}
+	scalars := make([]frontend.Variable, c.Count)
+	if err := arthur.FillChallengeScalars(scalars); err != nil {
+		return err
+	}
+	_, err = api.Compiler().NewHint(func(_ *big.Int, inputs []*big.Int, _ []*big.Int) error {
+		*c.scalars = (*c.scalars)[:0]
+		for _, value := range inputs {
+			*c.scalars = append(*c.scalars, new(big.Int).Set(value))
+		}
+		return nil
+	}, 1, scalars...)
+	return err
+}
+
+// squeezedScalars returns the count scalars the Skyscraper transcript over io
+// squeezes after absorbing transcript.
+func squeezedScalars(t *testing.T, io []byte, count int, transcript []uints.U8) []*big.Int {
+	t.Helper()
+	var scalars []*big.Int
+	shape := &squeezeProbeCircuit{IO: io, Count: count, Transcript: make([]uints.U8, len(transcript)), scalars: &scalars}
+	assignment := &squeezeProbeCircuit{IO: io, Count: count, Transcript: transcript, scalars: &scalars}
+	if err := test.IsSolved(shape, assignment, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("probe transcript failed: %v", err)
+	}
+	return scalars
+}
+
+// challengeDigest is the digest FillChallengeBytes reads out of scalars: the low 15
+// bytes of each, least significant first, truncated to length.
+func challengeDigest(scalars []*big.Int, length int) []byte {
+	var digest []byte
+	for _, scalar := range scalars {
+		word := scalar.FillBytes(make([]byte, 32))
+		for k := range 15 {
+			digest = append(digest, word[31-k])
+		}
+	}
+	return digest[:length]
+}
+
+// reducedRandomnessCircuit squeezes two independent combination coefficients with
+// Reduction and checks them against Expected.
+type reducedRandomnessCircuit struct {
+	Reduction  string
+	Transcript []uints.U8
+	Expected   []frontend.Variable
+}
+
+func (c *reducedRandomnessCircuit) Define(api frontend.API) error {
+	arthur, err := gnarkNimue.NewSkyscraperArthur(api, skyscraper.NewSkyscraper(api, 2), challengeProbeIO, c.Transcript, true)
+	if err != nil {
+		return err
+	}
+	if err := arthur.FillNextScalars(make([]frontend.Variable, 1)); err != nil {
+		return err
+	}
+	randomness, err := GenerateCombinationRandomness(api, arthur, 2, independentCombinationRandomness, c.Reduction)
+	if err != nil {
+		return err
+	}
+	for i := range randomness {
+		api.AssertIsEqual(randomness[i], c.Expected[i])
+	}
+	return nil
+}
+
+func TestCombinationRandomnessUsesTheConfiguredReduction(t *testing.T) {
+	transcript := seedTranscript(12345)
+	scalars := squeezedScalars(t, challengeProbeIO, challengeProbeScalars, transcript)
+
+	// Each challenge reads its own digest: 4 scalars for the 47 bytes of a modular
+	// reduction, 35 for the 512 bytes of rejection sampling.
+	expected := map[string][]frontend.Variable{
+		nativeChallengeReduction: {scalars[0], scalars[1]},
+		modularChallengeReduction: {
+			modularReductionNative(challengeDigest(scalars[0:4], 47)),
+			modularReductionNative(challengeDigest(scalars[4:8], 47)),
+		},
+	}
+	for i := range 2 {
+		value, ok := rejectionSamplingNative(challengeDigest(scalars[35*i:35*(i+1)], 512))
+		if !ok {
+			t.Fatalf("seed rejects every window of challenge %d", i)
+		}
+		expected[rejectionChallengeReduction] = append(expected[rejectionChallengeReduction], value)
+	}
+
+	for reduction := range expected {
+		shape := &reducedRandomnessCircuit{Reduction: reduction, Transcript: make([]uints.U8, len(transcript)), Expected: make([]frontend.Variable, 2)}
+		for reference, values := range expected {
+			assignment := &reducedRandomnessCircuit{Reduction: reduction, Transcript: transcript, Expected: values}
+			err := test.IsSolved(shape, assignment, ecc.BN254.ScalarField())
+			if reduction == reference && err != nil {
+				t.Errorf("%q challenges rejected: %v", reduction, err)
+			}
+			if reduction != reference && err == nil {
+				t.Errorf("%q reduction accepted %q challenges", reduction, reference)
+			}
+		}
+	}
+}
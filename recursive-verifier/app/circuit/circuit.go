@@ -1,7 +1,9 @@
 package circuit
 
 import (
+	"fmt"
 	"log"
+	"math/big"
 	"os"
 
 	"reilabs/whir-verifier-circuit/app/typeConverters"
@@ -14,6 +16,7 @@ import (
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/frontend/cs/r1cs"
 	"github.com/consensys/gnark/std/math/uints"
+	gnarkNimue "github.com/reilabs/gnark-nimue"
 )
 
 type Circuit struct {
@@ -23,6 +26,8 @@ type Circuit struct {
 	LogNumConstraints                       int
 	LogNumVariables                         int
 	LogANumTerms                            int
+	SpartanSumcheckDegree                   int
+	SumArgument                             bool
 	WitnessClaimedEvaluations               []frontend.Variable
 	WitnessBlindingEvaluations              []frontend.Variable
 	HidingSpartanFirstRound                 Merkle
@@ -36,8 +41,18 @@ type Circuit struct {
 	MatrixB []MatrixCell
 	MatrixC []MatrixCell
 	// Public Input
-	IO         []byte
-	Transcript []uints.U8 `gnark:",public"`
+	IO               []byte
+	TranscriptSponge string
+	Transcript       []uints.U8 `gnark:",public"`
+	// TranscriptFinalState holds the final transcript state the proof claims, if
+	// it claims one, so a verifier of the outer proof can bind it.
+	TranscriptFinalState []frontend.Variable `gnark:",public"`
+	// PublicInputs holds the public entries of the inner witness the proof is
+	// verified against, if any, see PublicInputsStatement.
+	PublicInputs []frontend.Variable `gnark:",public"`
+
+	// constraints is the relation the Spartan sumcheck checks, R1CS if unset.
+	constraints ConstraintSystem
 }
 
 func (circuit *Circuit) Define(api frontend.API) error {
@@ -46,7 +61,7 @@ func (circuit *Circuit) Define(api frontend.API) error {
 		return err
 	}
 
-	rootHash, batchingRandomness, initialOODQueries, initialOODAnswers, err := parseBatchedCommitment(arthur, circuit.WHIRParamsWitness)
+	rootHash, batchingRandomness, initialOODQueries, initialOODAnswers, err := parseBatchedCommitment(api, arthur, circuit.WHIRParamsWitness)
 
 	if err != nil {
 		return err
@@ -58,7 +73,7 @@ func (circuit *Circuit) Define(api frontend.API) error {
 		return err
 	}
 
-	spartanSumcheckRand, spartanSumcheckLastValue, err := runZKSumcheck(api, sc, uapi, circuit, arthur, frontend.Variable(0), circuit.LogNumConstraints, 4, circuit.WHIRParamsHidingSpartan)
+	spartanSumcheckRand, spartanSumcheckLastValue, err := runZKSumcheck(api, sc, uapi, circuit, arthur, frontend.Variable(0), circuit.LogNumConstraints, circuit.SpartanSumcheckDegree, circuit.WHIRParamsHidingSpartan)
 	if err != nil {
 		return err
 	}
@@ -69,109 +84,159 @@ func (circuit *Circuit) Define(api frontend.API) error {
 		return err
 	}
 
-	x := api.Mul(api.Sub(api.Mul(circuit.WitnessClaimedEvaluations[0], circuit.WitnessClaimedEvaluations[1]), circuit.WitnessClaimedEvaluations[2]), calculateEQ(api, spartanSumcheckRand, tRand))
-	api.AssertIsEqual(spartanSumcheckLastValue, x)
+	if circuit.SumArgument {
+		AssertSumsEqual(api, circuit.WitnessClaimedEvaluations[:matrixStatements], circuit.WitnessBlindingEvaluations[:matrixStatements])
+	}
+
+	constraints := circuit.constraints
+	if constraints == nil {
+		constraints = r1csConstraints{}
+	}
+	if err = AssertOuterSumcheckClaim(api, constraints, circuit.WitnessClaimedEvaluations[:matrixStatements], tRand, spartanSumcheckRand, spartanSumcheckLastValue); err != nil {
+		return err
+	}
 
 	matrixExtensionEvals := evaluateR1CSMatrixExtension(api, circuit, spartanSumcheckRand, whirFoldingRandomness)
 
-	for i := 0; i < 3; i++ {
+	for i := 0; i < matrixStatements; i++ {
 		api.AssertIsEqual(matrixExtensionEvals[i], circuit.WitnessLinearStatementEvaluations[i])
 	}
 
+	if len(circuit.PublicInputs) > 0 {
+		// The first Spartan sumcheck challenge is squeezed after the witness
+		// commitment, and VerifySpartan checks that there is one.
+		value, weightEvaluation := PublicInputsStatement(api, circuit.PublicInputs, spartanSumcheckRand[0], whirFoldingRandomness)
+		api.AssertIsEqual(circuit.WitnessClaimedEvaluations[publicInputsStatementIndex], value)
+		api.AssertIsEqual(circuit.WitnessLinearStatementEvaluations[publicInputsStatementIndex], weightEvaluation)
+	}
+
+	if len(circuit.TranscriptFinalState) > 0 {
+		return assertTranscriptFinalState(api, arthur, circuit.TranscriptFinalState[0])
+	}
+
 	return nil
 }
 
-func verifyCircuit(
-	deferred []Fp256, cfg Config, hints Hints, pk *groth16.ProvingKey, vk *groth16.VerifyingKey, outputCcsPath string, claimedEvaluations ClaimedEvaluations, internedR1CS R1CS, interner Interner,
-) error {
+// assertTranscriptFinalState asserts that the transcript ends in the state the
+// proof claims. The sponge is only observable through what it squeezes, so the
+// state is represented by one final challenge: it depends on every absorption and
+// squeeze before it, so the two sides agree on it only if they agreed on the
+// whole transcript.
+func assertTranscriptFinalState(api frontend.API, arthur gnarkNimue.Arthur, claimed frontend.Variable) error {
+	state := make([]frontend.Variable, 1)
+	if err := arthur.FillChallengeScalars(state); err != nil {
+		return err
+	}
+	api.AssertIsEqual(state[0], claimed)
+	return nil
+}
+
+// transcriptFinalState parses the final transcript state config claims, a decimal
+// field element.
+func (cfg Config) transcriptFinalState() (*big.Int, error) {
+	state, ok := new(big.Int).SetString(cfg.TranscriptFinalState, 10)
+	if !ok || state.Sign() < 0 || state.Cmp(ecc.BN254.ScalarField()) >= 0 {
+		return nil, fmt.Errorf("transcript_final_state %q is not a decimal field element", cfg.TranscriptFinalState)
+	}
+	return state, nil
+}
+
+// newAssignment builds the witness assignment of the verifier circuit from the
+// values parsed out of the transcript. The assignment also describes the shape of
+// the circuit, so it is the only copy of the proof data that is built: callers read
+// the witness out of it before compiling it as the circuit definition.
+func newAssignment(
+	deferred []Fp256, cfg Config, hints Hints, claimedEvaluations ClaimedEvaluations, internedR1CS R1CS, interner Interner, publicInputs []*big.Int,
+) Circuit {
 	transcriptT := make([]uints.U8, cfg.TranscriptLen)
-	contTranscript := make([]uints.U8, cfg.TranscriptLen)
 
 	for i := range cfg.Transcript {
 		transcriptT[i] = uints.NewU8(cfg.Transcript[i])
 	}
 
-	witnessLinearStatementEvaluations := make([]frontend.Variable, 3)
-	hidingSpartanLinearStatementEvaluations := make([]frontend.Variable, 1)
-	contWitnessLinearStatementEvaluations := make([]frontend.Variable, 3)
-	contHidingSpartanLinearStatementEvaluations := make([]frontend.Variable, 1)
-
-	hidingSpartanLinearStatementEvaluations[0] = typeConverters.LimbsToBigIntMod(deferred[0].Limbs)
-	witnessLinearStatementEvaluations[0] = typeConverters.LimbsToBigIntMod(deferred[1].Limbs)
-	witnessLinearStatementEvaluations[1] = typeConverters.LimbsToBigIntMod(deferred[2].Limbs)
-	witnessLinearStatementEvaluations[2] = typeConverters.LimbsToBigIntMod(deferred[3].Limbs)
+	// prepareCircuit has checked that deferred holds the hiding Spartan statements
+	// followed by the witness statements.
+	witnessLinearStatementEvaluations := make([]frontend.Variable, witnessStatements(len(publicInputs)))
+	hidingSpartanLinearStatementEvaluations := make([]frontend.Variable, hidingSpartanStatements)
 
-	fSums, gSums := parseClaimedEvaluations(claimedEvaluations, true)
-
-	matrixA := make([]MatrixCell, len(internedR1CS.A.Values))
-	for i := range len(internedR1CS.A.RowIndices) {
-		end := len(internedR1CS.A.Values) - 1
-		if i < len(internedR1CS.A.RowIndices)-1 {
-			end = int(internedR1CS.A.RowIndices[i+1] - 1)
-		}
-		for j := int(internedR1CS.A.RowIndices[i]); j <= end; j++ {
-			matrixA[j] = MatrixCell{
-				row:    i,
-				column: int(internedR1CS.A.ColIndices[j]),
-				value:  typeConverters.LimbsToBigIntMod(interner.Values[internedR1CS.A.Values[j]].Limbs),
-			}
-		}
+	for i := range hidingSpartanLinearStatementEvaluations {
+		hidingSpartanLinearStatementEvaluations[i] = typeConverters.LimbsToBigIntMod(deferred[i].Limbs)
+	}
+	for i := range witnessLinearStatementEvaluations {
+		witnessLinearStatementEvaluations[i] = typeConverters.LimbsToBigIntMod(deferred[hidingSpartanStatements+i].Limbs)
 	}
 
-	matrixB := make([]MatrixCell, len(internedR1CS.B.Values))
-	for i := range len(internedR1CS.B.RowIndices) {
-		end := len(internedR1CS.B.Values) - 1
-		if i < len(internedR1CS.B.RowIndices)-1 {
-			end = int(internedR1CS.B.RowIndices[i+1] - 1)
-		}
-		for j := int(internedR1CS.B.RowIndices[i]); j <= end; j++ {
-			matrixB[j] = MatrixCell{
-				row:    i,
-				column: int(internedR1CS.B.ColIndices[j]),
-				value:  typeConverters.LimbsToBigIntMod(interner.Values[internedR1CS.B.Values[j]].Limbs),
-			}
-		}
+	publicInputVariables := make([]frontend.Variable, len(publicInputs))
+	for i, input := range publicInputs {
+		publicInputVariables[i] = input
 	}
 
-	matrixC := make([]MatrixCell, len(internedR1CS.C.Values))
-	for i := range len(internedR1CS.C.RowIndices) {
-		end := len(internedR1CS.C.Values) - 1
-		if i < len(internedR1CS.C.RowIndices)-1 {
-			end = int(internedR1CS.C.RowIndices[i+1] - 1)
-		}
-		for j := int(internedR1CS.C.RowIndices[i]); j <= end; j++ {
-			matrixC[j] = MatrixCell{
-				row:    i,
-				column: int(internedR1CS.C.ColIndices[j]),
-				value:  typeConverters.LimbsToBigIntMod(interner.Values[internedR1CS.C.Values[j]].Limbs),
-			}
-		}
+	var transcriptFinalState []frontend.Variable
+	if cfg.TranscriptFinalState != "" {
+		// Validate has checked that the state parses.
+		state, _ := cfg.transcriptFinalState()
+		transcriptFinalState = []frontend.Variable{state}
 	}
 
-	var circuit = Circuit{
-		IO:                                      []byte(cfg.IOPattern),
-		Transcript:                              contTranscript,
-		LogNumConstraints:                       cfg.LogNumConstraints,
-		LogNumVariables:                         cfg.LogNumVariables,
-		LogANumTerms:                            cfg.LogANumTerms,
+	columnMajor := cfg.MatrixLayout == columnMajorLayout
+	constraints := newConstraintSystem(cfg, internedR1CS, interner)
+	matrixA := matrixCells(internedR1CS.A, interner, columnMajor)
+	matrixB := matrixCells(internedR1CS.B, interner, columnMajor)
+	matrixC := matrixCells(internedR1CS.C, interner, columnMajor)
+
+	fSums, gSums := parseClaimedEvaluations(claimedEvaluations)
+
+	witnessParams := NewWhirParams(cfg.WHIRConfigWitness)
+	witnessParams.NumStatements = len(witnessLinearStatementEvaluations)
+	hidingSpartanParams := NewWhirParams(cfg.WHIRConfigHidingSpartan)
+	hidingSpartanParams.NumStatements = hidingSpartanStatements
+
+	return Circuit{
+		IO:               []byte(cfg.IOPattern),
+		TranscriptSponge: cfg.TranscriptSponge,
+		Transcript:       transcriptT,
+
+		TranscriptFinalState: transcriptFinalState,
+		PublicInputs:         publicInputVariables,
+		LogNumConstraints:    cfg.LogNumConstraints,
+		LogNumVariables:      cfg.LogNumVariables,
+		LogANumTerms:         cfg.LogANumTerms,
+
+		SpartanSumcheckDegree: cfg.spartanSumcheckDegree(),
+		SumArgument:           cfg.SumArgument,
+
 		WitnessClaimedEvaluations:               fSums,
 		WitnessBlindingEvaluations:              gSums,
-		WitnessLinearStatementEvaluations:       contWitnessLinearStatementEvaluations,
-		HidingSpartanLinearStatementEvaluations: contHidingSpartanLinearStatementEvaluations,
-		HidingSpartanFirstRound:                 newMerkle(hints.spartanHidingHint.firstRoundMerklePaths.path, true),
-		HidingSpartanMerkle:                     newMerkle(hints.spartanHidingHint.roundHints, true),
-		WitnessMerkle:                           newMerkle(hints.witnessHints.roundHints, true),
-		WitnessFirstRound:                       newMerkle(hints.witnessHints.firstRoundMerklePaths.path, true),
+		WitnessLinearStatementEvaluations:       witnessLinearStatementEvaluations,
+		HidingSpartanLinearStatementEvaluations: hidingSpartanLinearStatementEvaluations,
 
-		WHIRParamsWitness:       NewWhirParams(cfg.WHIRConfigWitness),
-		WHIRParamsHidingSpartan: NewWhirParams(cfg.WHIRConfigHidingSpartan),
+		HidingSpartanFirstRound: newMerkle(hints.spartanHidingHint.firstRoundMerklePaths.path),
+		HidingSpartanMerkle:     newMerkle(hints.spartanHidingHint.roundHints),
+		WitnessMerkle:           newMerkle(hints.witnessHints.roundHints),
+		WitnessFirstRound:       newMerkle(hints.witnessHints.firstRoundMerklePaths.path),
+
+		WHIRParamsWitness:       witnessParams,
+		WHIRParamsHidingSpartan: hidingSpartanParams,
 
 		MatrixA: matrixA,
 		MatrixB: matrixB,
 		MatrixC: matrixC,
+
+		constraints: constraints,
 	}
+}
 
-	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, &circuit)
+// verifyCircuit proves and verifies assignment with Groth16. The witness is read
+// out of assignment first, since compiling it as the circuit definition replaces
+// its variables with wires.
+func verifyCircuit(
+	assignment *Circuit, pk *groth16.ProvingKey, vk *groth16.VerifyingKey, outputCcsPath string,
+) error {
+	witness, err := frontend.NewWitness(assignment, ecc.BN254.ScalarField())
+	if err != nil {
+		return fmt.Errorf("failed to build witness: %w", err)
+	}
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, assignment)
 	if err != nil {
 		log.Fatalf("Failed to compile circuit: %v", err)
 	}
@@ -198,34 +263,12 @@ func verifyCircuit(
 		vk = &unsafeVk
 	}
 
-	fSums, gSums = parseClaimedEvaluations(claimedEvaluations, false)
-
-	assignment := Circuit{
-		IO:                []byte(cfg.IOPattern),
-		Transcript:        transcriptT,
-		LogNumConstraints: cfg.LogNumConstraints,
-
-		WitnessClaimedEvaluations:               fSums,
-		WitnessBlindingEvaluations:              gSums,
-		WitnessLinearStatementEvaluations:       witnessLinearStatementEvaluations,
-		HidingSpartanLinearStatementEvaluations: hidingSpartanLinearStatementEvaluations,
-
-		HidingSpartanFirstRound: newMerkle(hints.spartanHidingHint.firstRoundMerklePaths.path, false),
-		HidingSpartanMerkle:     newMerkle(hints.spartanHidingHint.roundHints, false),
-		WitnessMerkle:           newMerkle(hints.witnessHints.roundHints, false),
-		WitnessFirstRound:       newMerkle(hints.witnessHints.firstRoundMerklePaths.path, false),
-
-		WHIRParamsWitness:       NewWhirParams(cfg.WHIRConfigWitness),
-		WHIRParamsHidingSpartan: NewWhirParams(cfg.WHIRConfigHidingSpartan),
-
-		MatrixA: matrixA,
-		MatrixB: matrixB,
-		MatrixC: matrixC,
-	}
-
-	witness, _ := frontend.NewWitness(&assignment, ecc.BN254.ScalarField())
 	publicWitness, _ := witness.Public()
-	proof, _ := groth16.Prove(ccs, *pk, witness, backend.WithSolverOptions(solver.WithHints(utilities.IndexOf)))
+	proof, err := groth16.Prove(ccs, *pk, witness, backend.WithSolverOptions(solver.WithHints(utilities.IndexOf)))
+	if err != nil {
+		log.Printf("Failed to prove: %v", err)
+		return err
+	}
 	err = groth16.Verify(proof, *vk, publicWitness)
 	if err != nil {
 		log.Printf("Failed to verify proof: %v", err)
@@ -234,16 +277,26 @@ func verifyCircuit(
 	return nil
 }
 
-func parseClaimedEvaluations(claimedEvaluations ClaimedEvaluations, isContainer bool) ([]frontend.Variable, []frontend.Variable) {
+func parseClaimedEvaluations(claimedEvaluations ClaimedEvaluations) ([]frontend.Variable, []frontend.Variable) {
 	fSums := make([]frontend.Variable, len(claimedEvaluations.FSums))
 	gSums := make([]frontend.Variable, len(claimedEvaluations.GSums))
 
-	if !isContainer {
-		for i := range claimedEvaluations.FSums {
-			fSums[i] = typeConverters.LimbsToBigIntMod(claimedEvaluations.FSums[i].Limbs)
-			gSums[i] = typeConverters.LimbsToBigIntMod(claimedEvaluations.GSums[i].Limbs)
-		}
+	for i := range claimedEvaluations.FSums {
+		fSums[i] = typeConverters.LimbsToBigIntMod(claimedEvaluations.FSums[i].Limbs)
+		gSums[i] = typeConverters.LimbsToBigIntMod(claimedEvaluations.GSums[i].Limbs)
 	}
 
 	return fSums, gSums
 }
+
+// defaultSpartanSumcheckDegree is the degree of the Spartan sumcheck round
+// polynomials, (A·z)(B·z) - (C·z) weighted by eq, when the configuration does not
+// specify one.
+const defaultSpartanSumcheckDegree = 3
+
+func (cfg Config) spartanSumcheckDegree() int {
+	if cfg.SpartanSumcheckDegree == 0 {
+		return defaultSpartanSumcheckDegree
+	}
+	return cfg.SpartanSumcheckDegree
+}
@@ -0,0 +1,79 @@
+package circuit
+
+import (
+	"reflect"
+	"testing"
+)
+
+// syntheticHint returns paths Merkle multi-paths of leaves openings each, with auth
+// paths of height digests and leaves of width field elements.
+func syntheticHint(paths int, leaves int, height int, width int) Hint {
+	hint := Hint{
+		merklePaths: make([]MultiPath[KeccakDigest], paths),
+		stirAnswers: make([][][]Fp256, paths),
+	}
+	for i := range paths {
+		path := MultiPath[KeccakDigest]{
+			LeafSiblingHashes:      make([]KeccakDigest, leaves),
+			AuthPathsPrefixLengths: make([]uint64, leaves),
+			AuthPathsSuffixes:      make([][]KeccakDigest, leaves),
+			LeafIndexes:            make([]uint64, leaves),
+		}
+		hint.stirAnswers[i] = make([][]Fp256, leaves)
+		for j := range leaves {
+			path.LeafIndexes[j] = uint64(j)
+			path.LeafSiblingHashes[j].KeccakDigest[0] = byte(j)
+			if j == 0 {
+				path.AuthPathsSuffixes[j] = make([]KeccakDigest, height)
+			} else {
+				path.AuthPathsPrefixLengths[j] = uint64(height - 1)
+				path.AuthPathsSuffixes[j] = make([]KeccakDigest, 1)
+			}
+			path.AuthPathsSuffixes[j][0].KeccakDigest[0] = byte(j)
+			hint.stirAnswers[i][j] = make([]Fp256, width)
+			for k := range width {
+				hint.stirAnswers[i][j][k].Limbs[0] = uint64(j*width + k + 1)
+			}
+		}
+		hint.merklePaths[i] = path
+	}
+	return hint
+}
+
+func syntheticAssignmentInputs() (Hint, Hints, Config) {
+	hint := syntheticHint(4, 256, 16, 32)
+	hints := Hints{witnessHints: ZKHint{firstRoundMerklePaths: FirstRoundHint{path: hint}}}
+	cfg := Config{
+		WHIRConfigWitness:       WHIRConfig{DomainGenerator: "1"},
+		WHIRConfigHidingSpartan: WHIRConfig{DomainGenerator: "1"},
+	}
+	return hint, hints, cfg
+}
+
+func TestNewAssignmentHoldsTheOpeningsOnce(t *testing.T) {
+	hint, hints, cfg := syntheticAssignmentInputs()
+	assignment := newAssignment(make([]Fp256, 4), cfg, hints, ClaimedEvaluations{}, R1CS{}, Interner{}, nil)
+
+	// The openings are laid out once, as assigned values, so the same value serves
+	// as the circuit definition and no container copy is needed.
+	if !reflect.DeepEqual(assignment.WitnessFirstRound, newMerkle(hint)) {
+		t.Fatal("assignment does not hold the openings of the hint")
+	}
+	for i, leaves := range assignment.WitnessFirstRound.Leaves {
+		for j, leaf := range leaves {
+			for k, value := range leaf {
+				if value == nil {
+					t.Fatalf("leaf %d/%d/%d of the assignment is unassigned", i, j, k)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkNewAssignment(b *testing.B) {
+	_, hints, cfg := syntheticAssignmentInputs()
+	b.ReportAllocs()
+	for range b.N {
+		newAssignment(make([]Fp256, 4), cfg, hints, ClaimedEvaluations{}, R1CS{}, Interner{}, nil)
+	}
+}
@@ -2,113 +2,361 @@ package circuit
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
 
+	"reilabs/whir-verifier-circuit/app/typeConverters"
+	"reilabs/whir-verifier-circuit/app/utilities"
+
+	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/constraint/solver"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
 	gnarkNimue "github.com/reilabs/gnark-nimue"
 	arkSerialize "github.com/reilabs/go-ark-serialize"
+	"golang.org/x/crypto/sha3"
 )
 
+func init() {
+	solver.RegisterHint(utilities.IndexOf)
+	solver.RegisterHint(checkFinalEvaluation)
+}
+
 func PrepareAndVerifyCircuit(config Config, r1cs R1CS, pk *groth16.ProvingKey, vk *groth16.VerifyingKey, outputCcsPath string) error {
-	io := gnarkNimue.IOPattern{}
-	err := io.Parse([]byte(config.IOPattern))
-	if err != nil {
-		return fmt.Errorf("failed to parse IO pattern: %w", err)
-	}
-
-	var pointer uint64
-	var truncated []byte
-
-	var merklePaths []MultiPath[KeccakDigest]
-	var stirAnswers [][][]Fp256
-	var deferred []Fp256
-	var claimedEvaluations ClaimedEvaluations
-
-	for _, op := range io.Ops {
-		switch op.Kind {
-		case gnarkNimue.Hint:
-			if pointer+4 > uint64(len(config.Transcript)) {
-				return fmt.Errorf("insufficient bytes for hint length")
-			}
-			hintLen := binary.LittleEndian.Uint32(config.Transcript[pointer : pointer+4])
-			start := pointer + 4
-			end := start + uint64(hintLen)
-
-			if end > uint64(len(config.Transcript)) {
-				return fmt.Errorf("insufficient bytes for merkle proof")
-			}
-
-			switch string(op.Label) {
-			case "merkle_proof":
-				var path MultiPath[KeccakDigest]
-				_, err = arkSerialize.CanonicalDeserializeWithMode(
-					bytes.NewReader(config.Transcript[start:end]),
-					&path,
-					false, false,
-				)
-				merklePaths = append(merklePaths, path)
-
-			case "stir_answers":
-				var stirAnswersTemporary [][]Fp256
-				_, err = arkSerialize.CanonicalDeserializeWithMode(
-					bytes.NewReader(config.Transcript[start:end]),
-					&stirAnswersTemporary,
-					false, false,
-				)
-				stirAnswers = append(stirAnswers, stirAnswersTemporary)
-
-			case "deferred_weight_evaluations":
-				var deferredTemporary []Fp256
-				_, err = arkSerialize.CanonicalDeserializeWithMode(
-					bytes.NewReader(config.Transcript[start:end]),
-					&deferredTemporary,
-					false, false,
-				)
-				if err != nil {
-					return fmt.Errorf("failed to deserialize deferred hint: %w", err)
-				}
-				deferred = append(deferred, deferredTemporary...)
-			case "claimed_evaluations":
-				_, err = arkSerialize.CanonicalDeserializeWithMode(
-					bytes.NewReader(config.Transcript[start:end]),
-					&claimedEvaluations,
-					false, false,
-				)
-				if err != nil {
-					return fmt.Errorf("failed to deserialize claimed_evaluations: %w", err)
-				}
-			}
-
-			if err != nil {
-				return fmt.Errorf("failed to deserialize merkle proof: %w", err)
-			}
-
-			pointer = end
-
-		case gnarkNimue.Absorb:
-			start := pointer
-			if string(op.Label) == "pow-nonce" {
-				pointer += op.Size
-			} else {
-				pointer += op.Size * 32
-			}
-
-			if pointer > uint64(len(config.Transcript)) {
-				return fmt.Errorf("absorb exceeds transcript length")
-			}
-
-			truncated = append(truncated, config.Transcript[start:pointer]...)
+	assignment, _, err := prepareCircuit(config, r1cs, nil)
+	if err != nil {
+		return err
+	}
+	err = verifyCircuit(&assignment, pk, vk, outputCcsPath)
+	if err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	return nil
+}
+
+// NativeOption configures NativeVerify.
+type NativeOption func(*nativeOptions)
+
+type nativeOptions struct {
+	reportMismatches bool
+}
+
+// WithMismatchReport makes NativeVerify return ErrFinalEvaluationMismatch, wrapped
+// in an error holding the expected and actual field values, when a final WHIR
+// evaluation check fails, so the values can be diffed against another verifier.
+func WithMismatchReport() NativeOption {
+	return func(o *nativeOptions) {
+		o.reportMismatches = true
+	}
+}
+
+// NativeVerify checks the proof carried by config against r1cs by solving the
+// verifier circuit on the parsed values. It performs the same checks as
+// PrepareAndVerifyCircuit without running Groth16.
+func NativeVerify(config Config, r1cs R1CS, opts ...NativeOption) error {
+	_, err := verifyNative(config, r1cs, nil, opts)
+	return err
+}
+
+// VerifySpartan verifies the proof carried by config against r1cs like NativeVerify,
+// and also checks that the public entries of the committed witness are
+// publicInputs, one value per public input of r1cs. The witness WHIR proof must
+// then carry the public IO statement of PublicInputsStatement after the three
+// matrix statements.
+func VerifySpartan(config Config, r1cs R1CS, publicInputs []*big.Int, opts ...NativeOption) error {
+	if uint64(len(publicInputs)) != r1cs.PublicInputs {
+		return fmt.Errorf("%d public inputs given, the r1cs has %d", len(publicInputs), r1cs.PublicInputs)
+	}
+	if len(publicInputs) == 0 {
+		return NativeVerify(config, r1cs, opts...)
+	}
+	if config.LogNumConstraints < 1 {
+		return fmt.Errorf("public inputs need at least one spartan sumcheck round, log_num_constraints is %d", config.LogNumConstraints)
+	}
+	if firstPublicInputIndex+len(publicInputs) > 1<<config.LogNumVariables {
+		return fmt.Errorf("%d public inputs do not fit in 2^%d witness entries", len(publicInputs), config.LogNumVariables)
+	}
+	for i, input := range publicInputs {
+		if input.Sign() < 0 || input.Cmp(ecc.BN254.ScalarField()) >= 0 {
+			return fmt.Errorf("public input %d is not a field element", i)
+		}
+	}
+	_, err := verifyNative(config, r1cs, publicInputs, opts)
+	return err
+}
+
+// verifyNative solves the verifier circuit for the proof carried by config and
+// returns the deferred statement values the proof was verified against.
+func verifyNative(config Config, r1cs R1CS, publicInputs []*big.Int, opts []NativeOption) ([]Fp256, error) {
+	var options nativeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	assignment, deferred, err := prepareCircuit(config, r1cs, publicInputs)
+	if err != nil {
+		return nil, err
+	}
+	assignment.WHIRParamsWitness.ReportMismatches = options.reportMismatches
+	assignment.WHIRParamsHidingSpartan.ReportMismatches = options.reportMismatches
+	err = solveNative(&assignment)
+	if err != nil {
+		return nil, fmt.Errorf("verification failed: %w", err)
+	}
+	return deferred, nil
+}
+
+// VerifyMerkleOnly checks every Merkle opening in the transcript of config against
+// the root absorbed for its commitment, including the openings used by the final
+// queries, and skips all sumcheck, folding and final evaluation checks. This tells a
+// commitment that does not open apart from openings that do not satisfy the claims.
+func VerifyMerkleOnly(config Config) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	io, err := parseIOPattern(config)
+	if err != nil {
+		return err
+	}
+
+	data, err := decodeTranscript(io, config.Transcript, config.FieldEncoding)
+	if err != nil {
+		return err
+	}
+
+	hidingRounds := config.WHIRConfigHidingSpartan.NRounds
+	if want := 2 + hidingRounds + config.WHIRConfigWitness.NRounds; len(data.merkleCaps) != want {
+		return fmt.Errorf("transcript has %d merkle roots, expected %d", len(data.merkleCaps), want)
+	}
+
+	// The witness is committed before the hiding polynomials, but the hiding WHIR
+	// proof is opened first. Reorder the caps to follow the order of the openings.
+	caps := [][][]byte{data.merkleCaps[1]}
+	caps = append(caps, data.merkleCaps[2:2+hidingRounds]...)
+	caps = append(caps, data.merkleCaps[0])
+	caps = append(caps, data.merkleCaps[2+hidingRounds:]...)
+
+	if len(data.merklePaths) != len(caps) || len(data.stirAnswers) != len(caps) {
+		return fmt.Errorf("transcript has %d merkle proofs and %d stir answers, expected %d of each", len(data.merklePaths), len(data.stirAnswers), len(caps))
+	}
+
+	hint := Hint{
+		merklePaths: data.merklePaths,
+		stirAnswers: data.stirAnswers,
+	}
+	assignment := merkleCircuit{
+		Caps:   make([][]frontend.Variable, len(caps)),
+		Merkle: newMerkle(hint),
+	}
+	for i, merkleCap := range caps {
+		assignment.Caps[i] = make([]frontend.Variable, len(merkleCap))
+		for j, node := range merkleCap {
+			assignment.Caps[i][j] = typeConverters.LittleEndianUint8ToBigInt(node)
 		}
 	}
 
-	config.Transcript = truncated
+	err = solveNative(&assignment)
+	if err != nil {
+		return fmt.Errorf("merkle verification failed: %w", err)
+	}
+	return nil
+}
+
+// solveNative checks that assignment satisfies the circuit it describes with the
+// gnark solver, without a proving backend. The witness is read out of assignment
+// before the circuit is compiled, since compiling replaces its variables with
+// wires, so assignment also serves as the circuit definition.
+func solveNative(assignment frontend.Circuit) error {
+	field := ecc.BN254.ScalarField()
+	witness, err := frontend.NewWitness(assignment, field)
+	if err != nil {
+		return fmt.Errorf("failed to build witness: %w", err)
+	}
+	ccs, err := frontend.Compile(field, r1cs.NewBuilder, assignment)
+	if err != nil {
+		return fmt.Errorf("failed to compile circuit: %w", err)
+	}
+	return ccs.IsSolved(witness)
+}
+
+// VerifyAndCommit verifies the proof carried by config like NativeVerify and returns
+// a commitment to its statement values, for an outer proof to bind to. The
+// commitment is the Keccak-256 digest of the deferred statement values (the hiding
+// Spartan statement value, then the witness statement values for A, B and C), each
+// encoded as 32 canonical little-endian bytes, concatenated in transcript order.
+func VerifyAndCommit(config Config, r1cs R1CS) (KeccakDigest, error) {
+	deferred, err := verifyNative(config, r1cs, nil, nil)
+	if err != nil {
+		return KeccakDigest{}, err
+	}
+	return statementCommitment(deferred), nil
+}
+
+// VerifyAuthenticated checks that tag is the AuthenticationTag of config and r1cs
+// under key, and then verifies the proof like NativeVerify. A proof, config or R1CS
+// altered in transit is rejected with ErrAuthenticationFailed before any
+// verification work is done. The tag only attests provenance; soundness still rests
+// on the verification alone.
+func VerifyAuthenticated(config Config, r1cs R1CS, tag []byte, key []byte) error {
+	expected, err := AuthenticationTag(config, r1cs, key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, tag) {
+		return ErrAuthenticationFailed
+	}
+	return NativeVerify(config, r1cs)
+}
+
+// AuthenticationTag returns the HMAC-SHA256 under key of the canonical encoding of
+// config and r1cs: the JSON object {"config": config, "r1cs": r1cs} as encoding/json
+// marshals it, which fixes the field order. The config carries the proof transcript
+// and all of its hints, so the tag covers everything the verification reads.
+func AuthenticationTag(config Config, r1cs R1CS, key []byte) ([]byte, error) {
+	encoded, err := json.Marshal(struct {
+		Config Config `json:"config"`
+		R1CS   R1CS   `json:"r1cs"`
+	}{config, r1cs})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode inputs for authentication: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(encoded)
+	return mac.Sum(nil), nil
+}
+
+func statementCommitment(statementValues []Fp256) KeccakDigest {
+	hasher := sha3.NewLegacyKeccak256()
+	var encoded [32]byte
+	for _, value := range statementValues {
+		for i, limb := range value.Limbs {
+			binary.LittleEndian.PutUint64(encoded[8*i:], limb)
+		}
+		hasher.Write(encoded[:])
+	}
+	var digest KeccakDigest
+	copy(digest.KeccakDigest[:], hasher.Sum(nil))
+	return digest
+}
+
+// PreflightCheck runs the checks on config and r1cs that need neither the circuit
+// nor the proof hints: the config is validated, the IO pattern must belong to the
+// protocol and match the configured sumcheck rounds, degrees and round schedule,
+// and the R1CS matrices must fit the configured dimensions.
+func PreflightCheck(config Config, r1cs R1CS) error {
+	if _, _, err := preflight(config); err != nil {
+		return err
+	}
+	return r1cs.validateShape(config)
+}
+
+// VerifyFile loads the config and R1CS JSON files that provekit-cli
+// generate-gnark-inputs writes, runs PreflightCheck and then NativeVerify, and
+// returns the first error encountered.
+func VerifyFile(configPath string, r1csPath string) error {
+	var config Config
+	if err := readJSONFile(configPath, &config); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	var r1cs R1CS
+	if err := readJSONFile(r1csPath, &r1cs); err != nil {
+		return fmt.Errorf("failed to load r1cs: %w", err)
+	}
+	if err := PreflightCheck(config, r1cs); err != nil {
+		return fmt.Errorf("preflight check failed: %w", err)
+	}
+	return NativeVerify(config, r1cs)
+}
+
+func readJSONFile(path string, v any) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(contents, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", path, err)
+	}
+	return nil
+}
+
+// preflight validates config and returns its parsed IO pattern together with the
+// round schedules of the hiding Spartan and witness WHIR proofs.
+func preflight(config Config) (gnarkNimue.IOPattern, [][]bool, error) {
+	if err := config.Validate(); err != nil {
+		return gnarkNimue.IOPattern{}, nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	io, err := parseIOPattern(config)
+	if err != nil {
+		return gnarkNimue.IOPattern{}, nil, err
+	}
+	if err := checkFinalSumcheckRounds(config, io); err != nil {
+		return gnarkNimue.IOPattern{}, nil, err
+	}
+	if err := checkFinalPolynomialDegrees(config, io); err != nil {
+		return gnarkNimue.IOPattern{}, nil, err
+	}
+	if err := checkSpartanSumcheckDegree(config, io); err != nil {
+		return gnarkNimue.IOPattern{}, nil, err
+	}
+	if err := checkSpartanSumcheckRounds(config, io); err != nil {
+		return gnarkNimue.IOPattern{}, nil, err
+	}
+	if err := checkTranscriptFinalState(config, io); err != nil {
+		return gnarkNimue.IOPattern{}, nil, err
+	}
+	schedules, err := roundSchedules(io, []int{config.WHIRConfigHidingSpartan.NRounds, config.WHIRConfigWitness.NRounds})
+	if err != nil {
+		return gnarkNimue.IOPattern{}, nil, err
+	}
+	return io, schedules, nil
+}
+
+// prepareCircuit splits the transcript in config into the hints and the absorbed
+// bytes, and builds the assignment of the verifier circuit. It also returns the
+// deferred statement values the assignment is built from. With publicInputs, the
+// witness WHIR proof must carry the public IO statement.
+func prepareCircuit(config Config, r1cs R1CS, publicInputs []*big.Int) (Circuit, []Fp256, error) {
+	io, schedules, err := preflight(config)
+	if err != nil {
+		return Circuit{}, nil, err
+	}
+	if err := r1cs.validateShape(config); err != nil {
+		return Circuit{}, nil, err
+	}
+	config.WHIRConfigHidingSpartan.roundOpensFirst = schedules[0]
+	config.WHIRConfigWitness.roundOpensFirst = schedules[1]
+
+	data, err := decodeTranscript(io, config.Transcript, config.FieldEncoding)
+	if err != nil {
+		return Circuit{}, nil, err
+	}
+	config.Transcript = data.absorbed
+
+	statements := witnessStatements(len(publicInputs))
+	if len(data.deferred) != hidingSpartanStatements+statements {
+		return Circuit{}, nil, fmt.Errorf("transcript has %d deferred weight evaluations, expected %d", len(data.deferred), hidingSpartanStatements+statements)
+	}
+	if len(data.claimedEvaluations.FSums) != statements || len(data.claimedEvaluations.GSums) != statements {
+		return Circuit{}, nil, fmt.Errorf("transcript claims %d and %d evaluations, expected %d of each", len(data.claimedEvaluations.FSums), len(data.claimedEvaluations.GSums), statements)
+	}
 
 	internerBytes, err := hex.DecodeString(r1cs.Interner.Values)
 	if err != nil {
-		return fmt.Errorf("failed to decode interner values: %w", err)
+		return Circuit{}, nil, fmt.Errorf("failed to decode interner values: %w", err)
 	}
 
 	var interner Interner
@@ -116,22 +364,31 @@ func PrepareAndVerifyCircuit(config Config, r1cs R1CS, pk *groth16.ProvingKey, v
 		bytes.NewReader(internerBytes), &interner, false, false,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to deserialize interner: %w", err)
+		return Circuit{}, nil, fmt.Errorf("failed to deserialize interner: %w", err)
 	}
 
-	var hidingSpartanData = consumeWhirData(config.WHIRConfigHidingSpartan, &merklePaths, &stirAnswers)
+	var hidingSpartanData = consumeWhirData(config.WHIRConfigHidingSpartan, &data.merklePaths, &data.stirAnswers)
+
+	var witnessData = consumeWhirData(config.WHIRConfigWitness, &data.merklePaths, &data.stirAnswers)
 
-	var witnessData = consumeWhirData(config.WHIRConfigWitness, &merklePaths, &stirAnswers)
+	if err := hidingSpartanData.ValidateStructure(); err != nil {
+		return Circuit{}, nil, fmt.Errorf("malformed hiding spartan hints: %w", err)
+	}
+	if err := witnessData.ValidateStructure(); err != nil {
+		return Circuit{}, nil, fmt.Errorf("malformed witness hints: %w", err)
+	}
+	if err := hidingSpartanData.checkQueryCounts(config.WHIRConfigHidingSpartan); err != nil {
+		return Circuit{}, nil, fmt.Errorf("hiding spartan hints: %w", err)
+	}
+	if err := witnessData.checkQueryCounts(config.WHIRConfigWitness); err != nil {
+		return Circuit{}, nil, fmt.Errorf("witness hints: %w", err)
+	}
 
 	hints := Hints{
 		witnessHints:      witnessData,
 		spartanHidingHint: hidingSpartanData,
 	}
-	err = verifyCircuit(deferred, config, hints, pk, vk, outputCcsPath, claimedEvaluations, r1cs, interner)
-	if err != nil {
-		return fmt.Errorf("verification failed: %w", err)
-	}
-	return nil
+	return newAssignment(data.deferred, config, hints, data.claimedEvaluations, r1cs, interner, publicInputs), data.deferred, nil
 }
 
 func GetPkAndVkFromPath(pkPath string, vkPath string) (*groth16.ProvingKey, *groth16.VerifyingKey, error) {
@@ -178,3 +435,55 @@ func GetR1csFromUrl(r1csUrl string) ([]byte, error) {
 	log.Printf("Successfully downloaded")
 	return r1csFile, nil
 }
+
+// Limits on the files LoadFromURL fetches. The config carries the prover
+// transcript, which stays within a few MiB even written out as a JSON array of
+// bytes. The R1CS of a circuit with millions of constraints is a few hundred MiB of
+// JSON.
+const (
+	maxConfigDownloadSize = 64 << 20
+	maxR1CSDownloadSize   = 512 << 20
+)
+
+// inputDownloadClient fetches the files of LoadFromURL. Its timeout bounds each
+// download even when the caller's context has no deadline, so a server that stalls
+// mid-response cannot hold the verifier indefinitely.
+var inputDownloadClient = &http.Client{Timeout: 5 * time.Minute}
+
+// LoadFromURL fetches the verifier inputs that provekit-cli generate-gnark-inputs
+// writes, params_for_recursive_verifier and r1cs.json, from baseURL and decodes
+// them. ctx bounds the downloads, each of which also times out on its own, and the
+// files are limited to maxConfigDownloadSize and maxR1CSDownloadSize bytes.
+func LoadFromURL(ctx context.Context, baseURL string) (Config, R1CS, error) {
+	configUrl, err := url.JoinPath(baseURL, "params_for_recursive_verifier")
+	if err != nil {
+		return Config{}, R1CS{}, fmt.Errorf("invalid base url %s: %w", baseURL, err)
+	}
+	r1csUrl, err := url.JoinPath(baseURL, "r1cs.json")
+	if err != nil {
+		return Config{}, R1CS{}, fmt.Errorf("invalid base url %s: %w", baseURL, err)
+	}
+
+	log.Printf("Downloading config from %s", configUrl)
+	configFile, err := downloadFromUrlWithContext(ctx, inputDownloadClient, configUrl, maxConfigDownloadSize)
+	if err != nil {
+		return Config{}, R1CS{}, fmt.Errorf("failed to download config file from url: %w", err)
+	}
+	var config Config
+	if err := json.Unmarshal(configFile, &config); err != nil {
+		return Config{}, R1CS{}, fmt.Errorf("failed to unmarshal config JSON: %w", err)
+	}
+
+	log.Printf("Downloading R1CS from %s", r1csUrl)
+	r1csFile, err := downloadFromUrlWithContext(ctx, inputDownloadClient, r1csUrl, maxR1CSDownloadSize)
+	if err != nil {
+		return Config{}, R1CS{}, fmt.Errorf("failed to download r1cs file from url: %w", err)
+	}
+	var r1cs R1CS
+	if err := json.Unmarshal(r1csFile, &r1cs); err != nil {
+		return Config{}, R1CS{}, fmt.Errorf("failed to unmarshal r1cs JSON: %w", err)
+	}
+
+	log.Printf("Successfully downloaded")
+	return config, r1cs, nil
+}
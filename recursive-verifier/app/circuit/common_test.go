@@ -0,0 +1,394 @@
+package circuit
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark/frontend"
+	"golang.org/x/crypto/sha3"
+)
+
+// finalEvaluationCircuit runs the final WHIR evaluation check NativeVerify solves,
+// with or without WithMismatchReport.
+type finalEvaluationCircuit struct {
+	Report   bool
+	Actual   frontend.Variable
+	Expected frontend.Variable
+}
+
+func (c *finalEvaluationCircuit) Define(api frontend.API) error {
+	return assertFinalEvaluation(api, c.Report, c.Actual, c.Expected)
+}
+
+func TestSolveNativeFinalEvaluation(t *testing.T) {
+	for _, report := range []bool{false, true} {
+		if err := solveNative(&finalEvaluationCircuit{Report: report, Actual: 42, Expected: 42}); err != nil {
+			t.Errorf("matching final evaluation rejected with report %v: %v", report, err)
+		}
+	}
+
+	err := solveNative(&finalEvaluationCircuit{Report: true, Actual: 41, Expected: 42})
+	if !errors.Is(err, ErrFinalEvaluationMismatch) {
+		t.Fatalf("mismatch with report returned %v", err)
+	}
+	// Without the report the mismatch is only an unsatisfied constraint.
+	err = solveNative(&finalEvaluationCircuit{Actual: 41, Expected: 42})
+	if err == nil || errors.Is(err, ErrFinalEvaluationMismatch) {
+		t.Fatalf("mismatch without report returned %v", err)
+	}
+}
+
+func TestWithMismatchReport(t *testing.T) {
+	var options nativeOptions
+	WithMismatchReport()(&options)
+	if !options.reportMismatches {
+		t.Fatal("WithMismatchReport does not enable mismatch reports")
+	}
+}
+
+func TestVerifyAuthenticatedCoversConfigAndR1CS(t *testing.T) {
+	key := []byte("key")
+	config := Config{Transcript: []byte{1, 2, 3}, LogNumConstraints: 4}
+	r1cs := R1CS{Constraints: 16}
+	tag, err := AuthenticationTag(config, r1cs, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The inputs do not verify, so an authenticated call fails past the tag check.
+	if err := VerifyAuthenticated(config, r1cs, tag, key); err == nil || errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("authentic inputs rejected by the tag check: %v", err)
+	}
+
+	alteredConfig := config
+	alteredConfig.LogNumConstraints = 5
+	alteredR1CS := r1cs
+	alteredR1CS.Constraints = 32
+	for name, inputs := range map[string]struct {
+		config Config
+		r1cs   R1CS
+	}{
+		"config": {alteredConfig, r1cs},
+		"r1cs":   {config, alteredR1CS},
+	} {
+		if err := VerifyAuthenticated(inputs.config, inputs.r1cs, tag, key); !errors.Is(err, ErrAuthenticationFailed) {
+			t.Errorf("altered %s not rejected by the tag check: %v", name, err)
+		}
+	}
+}
+
+// inputServer serves config and r1cs as the files LoadFromURL fetches. A handler
+// in overrides replaces the one of its path.
+func inputServer(t *testing.T, config Config, r1cs R1CS, overrides map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	for path, value := range map[string]any{"/params_for_recursive_verifier": config, "/r1cs.json": r1cs} {
+		if handler, ok := overrides[path]; ok {
+			mux.HandleFunc(path, handler)
+			continue
+		}
+		body, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux.HandleFunc(path, func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(body)
+		})
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestLoadFromURL(t *testing.T) {
+	config := Config{LogNumConstraints: 3, IOPattern: "io"}
+	r1cs := R1CS{Constraints: 8, Witnesses: 5}
+	server := inputServer(t, config, r1cs, nil)
+	gotConfig, gotR1CS, err := LoadFromURL(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("LoadFromURL failed: %v", err)
+	}
+	if gotConfig.LogNumConstraints != 3 || gotConfig.IOPattern != "io" || gotR1CS.Constraints != 8 || gotR1CS.Witnesses != 5 {
+		t.Fatalf("LoadFromURL decoded %+v and %+v", gotConfig, gotR1CS)
+	}
+}
+
+func TestLoadFromURLRejectsFailedDownloads(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		path    string
+		handler http.HandlerFunc
+		message string
+	}{
+		{
+			"missing config", "/params_for_recursive_verifier",
+			func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNotFound) },
+			"HTTP error 404",
+		},
+		{
+			"r1cs over the size limit", "/r1cs.json",
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Length", strconv.Itoa(maxR1CSDownloadSize+1))
+				_, _ = w.Write([]byte("{}"))
+			},
+			"exceeding the limit",
+		},
+	} {
+		server := inputServer(t, Config{}, R1CS{}, map[string]http.HandlerFunc{tc.path: tc.handler})
+		_, _, err := LoadFromURL(context.Background(), server.URL)
+		if err == nil || !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("%s: LoadFromURL returned %v, expected %q", tc.name, err, tc.message)
+		}
+	}
+}
+
+func TestDownloadLimitsUnannouncedLengths(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// Flushing before the body is complete sends it chunked, without a length.
+		_, _ = w.Write([]byte("0123"))
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("4567"))
+	}))
+	defer server.Close()
+
+	if body, err := downloadFromUrlWithContext(context.Background(), server.Client(), server.URL, 8); err != nil || string(body) != "01234567" {
+		t.Fatalf("download within the limit returned %q, %v", body, err)
+	}
+	if _, err := downloadFromUrlWithContext(context.Background(), server.Client(), server.URL, 7); err == nil || !strings.Contains(err.Error(), "exceeds the limit") {
+		t.Fatalf("download over the limit returned %v", err)
+	}
+}
+
+// arkEncode serialises v the way arkworks' CanonicalSerialize does for the types
+// the transcript hints use: slices are prefixed with their u64 length and integers
+// are little-endian.
+func arkEncode(v reflect.Value) []byte {
+	switch v.Kind() {
+	case reflect.Uint8:
+		return []byte{uint8(v.Uint())}
+	case reflect.Uint64:
+		return binary.LittleEndian.AppendUint64(nil, v.Uint())
+	case reflect.Slice:
+		out := binary.LittleEndian.AppendUint64(nil, uint64(v.Len()))
+		for i := range v.Len() {
+			out = append(out, arkEncode(v.Index(i))...)
+		}
+		return out
+	case reflect.Array:
+		var out []byte
+		for i := range v.Len() {
+			out = append(out, arkEncode(v.Index(i))...)
+		}
+		return out
+	case reflect.Struct:
+		var out []byte
+		for i := range v.NumField() {
+			out = append(out, arkEncode(v.Field(i))...)
+		}
+		return out
+	}
+	panic(fmt.Sprintf("arkEncode: unsupported type %v", v.Type()))
+}
+
+// hintBytes frames a hint payload with its little-endian u32 length.
+func hintBytes(payload []byte) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(payload))), payload...)
+}
+
+// merkleOnlyConfig returns a config whose transcript absorbs roots Merkle roots and
+// carries the first proofs openings of hint.
+func merkleOnlyConfig(roots int, proofs int, hint Hint) Config {
+	config := Config{
+		WHIRConfigWitness:       validWHIRConfig(),
+		WHIRConfigHidingSpartan: validWHIRConfig(),
+		LogNumVariables:         validWHIRConfig().NVars,
+		IOPattern:               protocolDomainSeparator,
+	}
+	for range roots {
+		config.IOPattern += "\x00A1" + merkleDigestLabel
+		config.Transcript = append(config.Transcript, scalarBytes(1)...)
+	}
+	for i := range proofs {
+		config.IOPattern += "\x00Hmerkle_proof\x00Hstir_answers"
+		config.Transcript = append(config.Transcript, hintBytes(arkEncode(reflect.ValueOf(hint.merklePaths[i])))...)
+		config.Transcript = append(config.Transcript, hintBytes(arkEncode(reflect.ValueOf(hint.stirAnswers[i])))...)
+	}
+	config.IOPattern += "\x00"
+	return config
+}
+
+func TestVerifyMerkleOnly(t *testing.T) {
+	// Two commitments, each with one round: four roots and four openings.
+	hint := syntheticHint(4, 2, 3, 2)
+	for _, tc := range []struct {
+		name          string
+		roots, proofs int
+		message       string
+	}{
+		{"missing root", 3, 4, "transcript has 3 merkle roots, expected 4"},
+		{"missing opening", 4, 3, "transcript has 3 merkle proofs and 3 stir answers, expected 4 of each"},
+		{"openings of other roots", 4, 4, "merkle verification failed"},
+	} {
+		err := VerifyMerkleOnly(merkleOnlyConfig(tc.roots, tc.proofs, hint))
+		if err == nil || !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("%s: VerifyMerkleOnly returned %v, expected %q", tc.name, err, tc.message)
+		}
+	}
+
+	invalid := merkleOnlyConfig(4, 4, hint)
+	invalid.LogNumVariables++
+	if err := VerifyMerkleOnly(invalid); err == nil || !strings.HasPrefix(err.Error(), "invalid config") {
+		t.Errorf("invalid config: VerifyMerkleOnly returned %v", err)
+	}
+}
+
+// preflightInputs returns a config and an R1CS that pass PreflightCheck: two
+// Spartan rounds and two single-round WHIR proofs over 8 variables, folded 4 at a
+// time and so without final sumcheck rounds, the witness proof opening after it
+// commits to the folded polynomial.
+func preflightInputs() (Config, R1CS) {
+	var pattern strings.Builder
+	pattern.WriteString(protocolDomainSeparator)
+	op := func(op string, count int) {
+		for range count {
+			pattern.WriteString("\x00" + op)
+		}
+	}
+	op("A4"+spartanSumcheckPolynomialLabel, 2)
+	// The hiding Spartan proof.
+	op("A1"+merkleDigestLabel, 1)
+	op("A1"+merkleDigestLabel, 1)
+	op("Hstir_answers", 1)
+	op("A1"+finalCoefficientsLabel, 1)
+	op("Hstir_answers", 1)
+	op("Hclaimed_evaluations", 1)
+	// The witness proof.
+	op("A1"+merkleDigestLabel, 1)
+	op("A3"+sumcheckPolynomialLabel, 4)
+	op("A1"+merkleDigestLabel, 1)
+	op("Hstir_answers", 1)
+	op("A3"+sumcheckPolynomialLabel, 4)
+	op("A1"+finalCoefficientsLabel, 1)
+	op("Hstir_answers", 1)
+	op("Hdeferred_weight_evaluations", 1)
+	pattern.WriteString("\x00")
+
+	config := Config{
+		WHIRConfigWitness:       validWHIRConfig(),
+		WHIRConfigHidingSpartan: validWHIRConfig(),
+		LogNumConstraints:       2,
+		LogNumVariables:         8,
+		IOPattern:               pattern.String(),
+	}
+	return config, R1CS{Constraints: 4, Witnesses: 100}
+}
+
+func TestPreflightCheck(t *testing.T) {
+	config, r1cs := preflightInputs()
+	if err := PreflightCheck(config, r1cs); err != nil {
+		t.Fatalf("consistent inputs rejected: %v", err)
+	}
+
+	for name, tamper := range map[string]func(*Config, *R1CS){
+		"invalid config":               func(c *Config, _ *R1CS) { c.LogNumVariables = 9 },
+		"foreign IO pattern":           func(c *Config, _ *R1CS) { c.IOPattern = "whir" + c.IOPattern[len(protocolDomainSeparator):] },
+		"missing Spartan round":        func(c *Config, _ *R1CS) { c.LogNumConstraints = 3 },
+		"witnesses of another size":    func(_ *Config, r *R1CS) { r.Witnesses = 64 },
+		"matrix wider than the config": func(_ *Config, r *R1CS) { r.B.Cols = 257 },
+	} {
+		config, r1cs := preflightInputs()
+		tamper(&config, &r1cs)
+		if err := PreflightCheck(config, r1cs); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, value any) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		contents, ok := value.([]byte)
+		if !ok {
+			var err error
+			if contents, err = json.Marshal(value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(path, contents, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	config, r1cs := preflightInputs()
+	configPath, r1csPath := write("config.json", config), write("r1cs.json", r1cs)
+	malformed := write("malformed.json", []byte("{"))
+	unchecked := r1cs
+	unchecked.Witnesses = 64
+	uncheckedPath := write("unchecked.json", unchecked)
+
+	for _, tc := range []struct {
+		name, configPath, r1csPath, message string
+	}{
+		{"missing config", filepath.Join(dir, "missing.json"), r1csPath, "failed to load config"},
+		{"malformed r1cs", configPath, malformed, "failed to load r1cs: failed to unmarshal"},
+		{"inconsistent r1cs", configPath, uncheckedPath, "preflight check failed"},
+	} {
+		err := VerifyFile(tc.configPath, tc.r1csPath)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.message) {
+			t.Errorf("%s: VerifyFile returned %v, expected %q", tc.name, err, tc.message)
+		}
+	}
+
+	// Consistent inputs pass the preflight check and fail verification, as the
+	// config carries no proof.
+	err := VerifyFile(configPath, r1csPath)
+	if err == nil || strings.HasPrefix(err.Error(), "preflight check failed") {
+		t.Fatalf("inputs without a proof: VerifyFile returned %v", err)
+	}
+}
+
+func TestStatementCommitment(t *testing.T) {
+	values := []Fp256{{Limbs: [4]uint64{1}}, {Limbs: [4]uint64{2, 0, 0, 3}}, {Limbs: [4]uint64{4}}}
+	commitment := statementCommitment(values)
+
+	// The values are hashed as 32 little-endian bytes each, in order.
+	encoded := make([]byte, 3*32)
+	encoded[0], encoded[32], encoded[32+24], encoded[64] = 1, 2, 3, 4
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write(encoded)
+	if !bytes.Equal(commitment.KeccakDigest[:], hasher.Sum(nil)) {
+		t.Fatal("commitment is not the Keccak-256 digest of the encoded values")
+	}
+
+	for i := range values {
+		for limb := range 4 {
+			changed := slices.Clone(values)
+			changed[i].Limbs[limb]++
+			if statementCommitment(changed) == commitment {
+				t.Errorf("changing limb %d of deferred value %d keeps the commitment", limb, i)
+			}
+		}
+	}
+	swapped := []Fp256{values[1], values[0], values[2]}
+	if statementCommitment(swapped) == commitment {
+		t.Error("reordering the deferred values keeps the commitment")
+	}
+	if statementCommitment(values[:2]) == commitment {
+		t.Error("dropping a deferred value keeps the commitment")
+	}
+}
@@ -0,0 +1,133 @@
+package circuit
+
+import (
+	"fmt"
+	"math/big"
+
+	"reilabs/whir-verifier-circuit/app/typeConverters"
+
+	"github.com/consensys/gnark/frontend"
+)
+
+// Constraint systems the Spartan layer can check satisfiability of.
+const (
+	// r1csConstraintSystem checks a*b - c = 0 on every row. This is the default.
+	r1csConstraintSystem = "r1cs"
+	// plonkishConstraintSystem checks the vanilla Plonk gate
+	// qL*a + qR*b + qM*a*b + qO*c + qC = 0 on every row, where the matrices select
+	// the left, right and output wires a, b and c and the selectors are per row.
+	plonkishConstraintSystem = "plonkish"
+)
+
+// plonkishSpartanSumcheckDegree is the Spartan sumcheck degree the Plonkish gate
+// needs: eq(tau, x) * qM(x) * a(x) * b(x) has degree 4 in each variable.
+const plonkishSpartanSumcheckDegree = 4
+
+// ConstraintSystem is the per-row relation the Spartan outer sumcheck proves holds.
+// The sumcheck, the WHIR openings and the matrix evaluations do not depend on it;
+// only the value the last sumcheck round must reduce to does.
+type ConstraintSystem interface {
+	// EvaluateConstraints returns the multilinear extension of the per-row
+	// constraint error at point, given evaluations, the values of Az, Bz and Cz
+	// there.
+	EvaluateConstraints(api frontend.API, evaluations []frontend.Variable, point []frontend.Variable) frontend.Variable
+}
+
+// r1csConstraints is the R1CS relation Az*Bz - Cz = 0.
+type r1csConstraints struct{}
+
+func (r1csConstraints) EvaluateConstraints(api frontend.API, evaluations []frontend.Variable, _ []frontend.Variable) frontend.Variable {
+	return api.Sub(api.Mul(evaluations[0], evaluations[1]), evaluations[2])
+}
+
+// SparseVector is a sparse column of per-row values, each resolved through the
+// interner like the matrix values.
+type SparseVector struct {
+	Indices []uint64 `json:"indices"`
+	Values  []uint64 `json:"values"`
+}
+
+// PlonkishSelectors are the selector columns of a Plonkish constraint system.
+type PlonkishSelectors struct {
+	QL SparseVector `json:"q_l"`
+	QR SparseVector `json:"q_r"`
+	QM SparseVector `json:"q_m"`
+	QO SparseVector `json:"q_o"`
+	QC SparseVector `json:"q_c"`
+}
+
+type selectorCell struct {
+	row   int
+	value *big.Int
+}
+
+// plonkishConstraints is the Plonk gate relation with the selectors in the
+// circuit as constants.
+type plonkishConstraints struct {
+	qL, qR, qM, qO, qC []selectorCell
+}
+
+func (p plonkishConstraints) EvaluateConstraints(api frontend.API, evaluations []frontend.Variable, point []frontend.Variable) frontend.Variable {
+	eq := calculateEQOverBooleanHypercube(api, point)
+	selector := func(cells []selectorCell) frontend.Variable {
+		value := frontend.Variable(0)
+		for _, cell := range cells {
+			value = api.Add(value, api.Mul(cell.value, eq[cell.row]))
+		}
+		return value
+	}
+	a, b, c := evaluations[0], evaluations[1], evaluations[2]
+	return api.Add(
+		api.Mul(selector(p.qL), a),
+		api.Mul(selector(p.qR), b),
+		api.Mul(selector(p.qM), a, b),
+		api.Mul(selector(p.qO), c),
+		selector(p.qC),
+	)
+}
+
+func selectorCells(vector SparseVector, interner Interner) []selectorCell {
+	cells := make([]selectorCell, len(vector.Values))
+	for i := range vector.Values {
+		cells[i] = selectorCell{
+			row:   int(vector.Indices[i]),
+			value: typeConverters.LimbsToBigIntMod(interner.Values[vector.Values[i]].Limbs),
+		}
+	}
+	return cells
+}
+
+// newConstraintSystem returns the constraint system config selects, built from the
+// selectors of r1cs when it is Plonkish.
+func newConstraintSystem(cfg Config, r1cs R1CS, interner Interner) ConstraintSystem {
+	if cfg.ConstraintSystem != plonkishConstraintSystem {
+		return r1csConstraints{}
+	}
+	return plonkishConstraints{
+		qL: selectorCells(r1cs.Selectors.QL, interner),
+		qR: selectorCells(r1cs.Selectors.QR, interner),
+		qM: selectorCells(r1cs.Selectors.QM, interner),
+		qO: selectorCells(r1cs.Selectors.QO, interner),
+		qC: selectorCells(r1cs.Selectors.QC, interner),
+	}
+}
+
+// validateShape checks that every selector row is one of the 2^log_num_constraints
+// rows the circuit evaluates.
+func (s PlonkishSelectors) validateShape(cfg Config) error {
+	maxRows := uint64(1) << cfg.LogNumConstraints
+	for _, v := range []struct {
+		name   string
+		vector SparseVector
+	}{{"q_l", s.QL}, {"q_r", s.QR}, {"q_m", s.QM}, {"q_o", s.QO}, {"q_c", s.QC}} {
+		if len(v.vector.Indices) != len(v.vector.Values) {
+			return fmt.Errorf("selector %s has %d indices for %d values", v.name, len(v.vector.Indices), len(v.vector.Values))
+		}
+		for j, row := range v.vector.Indices {
+			if row >= maxRows {
+				return fmt.Errorf("selector %s index %d = %d is out of range for %d rows", v.name, j, row, maxRows)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package circuit
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// constraintErrorCircuit asserts that the constraint error of constraints at Point
+// is zero, given the wire values Evaluations there.
+type constraintErrorCircuit struct {
+	constraints ConstraintSystem
+	Evaluations []frontend.Variable
+	Point       []frontend.Variable
+}
+
+func (c *constraintErrorCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(c.constraints.EvaluateConstraints(api, c.Evaluations, c.Point), 0)
+	return nil
+}
+
+func fp256(value *big.Int) Fp256 {
+	var limbs [4]uint64
+	word := new(big.Int).Set(value)
+	for i := range limbs {
+		limbs[i] = word.Uint64()
+		word.Rsh(word, 64)
+	}
+	return Fp256{Limbs: limbs}
+}
+
+func checkConstraintRow(t *testing.T, constraints ConstraintSystem, point []frontend.Variable, satisfied []frontend.Variable, violated []frontend.Variable) {
+	t.Helper()
+	shape := &constraintErrorCircuit{constraints: constraints, Evaluations: make([]frontend.Variable, 3), Point: make([]frontend.Variable, len(point))}
+	if err := test.IsSolved(shape, &constraintErrorCircuit{constraints: constraints, Evaluations: satisfied, Point: point}, ecc.BN254.ScalarField()); err != nil {
+		t.Errorf("satisfied row rejected: %v", err)
+	}
+	if err := test.IsSolved(shape, &constraintErrorCircuit{constraints: constraints, Evaluations: violated, Point: point}, ecc.BN254.ScalarField()); err == nil {
+		t.Error("violated row accepted")
+	}
+}
+
+func TestR1CSConstraints(t *testing.T) {
+	checkConstraintRow(t, r1csConstraints{}, []frontend.Variable{0, 1},
+		[]frontend.Variable{3, 4, 12},
+		[]frontend.Variable{3, 4, 13},
+	)
+}
+
+func TestPlonkishConstraints(t *testing.T) {
+	// Row 1 is the addition gate a + b - c = 0 and row 2 the multiplication gate
+	// a*b - c + 1 = 0; rows 0 and 3 have no gate.
+	minusOne := new(big.Int).Sub(ecc.BN254.ScalarField(), big.NewInt(1))
+	interner := Interner{Values: []Fp256{fp256(big.NewInt(1)), fp256(minusOne)}}
+	r1cs := R1CS{Selectors: &PlonkishSelectors{
+		QL: SparseVector{Indices: []uint64{1}, Values: []uint64{0}},
+		QR: SparseVector{Indices: []uint64{1}, Values: []uint64{0}},
+		QM: SparseVector{Indices: []uint64{2}, Values: []uint64{0}},
+		QO: SparseVector{Indices: []uint64{1, 2}, Values: []uint64{1, 1}},
+		QC: SparseVector{Indices: []uint64{2}, Values: []uint64{0}},
+	}}
+	constraints := newConstraintSystem(Config{ConstraintSystem: plonkishConstraintSystem}, r1cs, interner)
+
+	// The first coordinate of the point is the most significant bit of the row.
+	checkConstraintRow(t, constraints, []frontend.Variable{0, 1},
+		[]frontend.Variable{3, 4, 7},
+		[]frontend.Variable{3, 4, 12},
+	)
+	checkConstraintRow(t, constraints, []frontend.Variable{1, 0},
+		[]frontend.Variable{3, 4, 13},
+		[]frontend.Variable{3, 4, 7},
+	)
+	// A row without a gate takes any wire values, so it has no violated row.
+	shape := &constraintErrorCircuit{constraints: constraints, Evaluations: make([]frontend.Variable, 3), Point: make([]frontend.Variable, 2)}
+	if err := test.IsSolved(shape, &constraintErrorCircuit{constraints: constraints, Evaluations: []frontend.Variable{3, 4, 5}, Point: []frontend.Variable{1, 1}}, ecc.BN254.ScalarField()); err != nil {
+		t.Errorf("row without a gate rejected: %v", err)
+	}
+}
+
+func TestPlonkishSelectorsValidateShape(t *testing.T) {
+	cfg := Config{LogNumConstraints: 2}
+	valid := PlonkishSelectors{QL: SparseVector{Indices: []uint64{0, 3}, Values: []uint64{0, 0}}}
+	if err := valid.validateShape(cfg); err != nil {
+		t.Fatalf("valid selectors rejected: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		selectors PlonkishSelectors
+		message   string
+	}{
+		"row out of range": {
+			PlonkishSelectors{QM: SparseVector{Indices: []uint64{4}, Values: []uint64{0}}},
+			"selector q_m index 0 = 4 is out of range for 4 rows",
+		},
+		"indices without values": {
+			PlonkishSelectors{QC: SparseVector{Indices: []uint64{0, 1}, Values: []uint64{0}}},
+			"selector q_c has 2 indices for 1 values",
+		},
+	} {
+		err := tc.selectors.validateShape(cfg)
+		if err == nil || !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("%s: got %v, expected %q", name, err, tc.message)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package circuit
+
+import "errors"
+
+var (
+	// ErrFinalRoundCountMismatch is returned when a WHIR proof does not send exactly
+	// FinalSumcheckRounds sumcheck polynomials in its final phase.
+	ErrFinalRoundCountMismatch = errors.New("final sumcheck round count mismatch")
+	// ErrFinalEvaluationMismatch is returned by NativeVerify with WithMismatchReport
+	// when the final WHIR evaluation check fails. The wrapping error carries the
+	// expected and actual field values.
+	ErrFinalEvaluationMismatch = errors.New("final evaluation mismatch")
+	// ErrFinalPolynomialDegree is returned when a WHIR proof sends a final
+	// polynomial with more coefficients than its final sumcheck rounds allow.
+	ErrFinalPolynomialDegree = errors.New("final polynomial degree exceeds bound")
+	// ErrQueryCountMismatch is returned when the Merkle openings of a WHIR round do
+	// not match the number of query indices the transcript derives for it.
+	ErrQueryCountMismatch = errors.New("query count mismatch")
+	// ErrPointDimensionMismatch is returned when the folding randomness a WHIR proof
+	// accumulates does not have one coordinate per variable of the polynomial.
+	ErrPointDimensionMismatch = errors.New("point dimension mismatch")
+	// ErrAuthenticationFailed is returned by VerifyAuthenticated when the tag does
+	// not authenticate the config and R1CS.
+	ErrAuthenticationFailed = errors.New("proof authentication failed")
+	// ErrFailureNotReproduced is returned by ReplayFailure when the replayed
+	// verification does not fail the way the dumped one did.
+	ErrFailureNotReproduced = errors.New("failure not reproduced")
+)
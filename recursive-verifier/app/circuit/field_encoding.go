@@ -0,0 +1,110 @@
+package circuit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Serializations of the field elements inside prover hints.
+const (
+	// fixedFieldEncoding is the arkworks encoding: every element takes 32
+	// little-endian bytes. This is the default.
+	fixedFieldEncoding = "fixed"
+	// lengthPrefixedFieldEncoding stores every element in its minimal width: a
+	// single byte holding the width, followed by that many little-endian bytes.
+	lengthPrefixedFieldEncoding = "length_prefixed"
+)
+
+// fieldElementBytes is the width of a field element in the fixed encoding.
+const fieldElementBytes = 32
+
+// fixedWidthHint rewrites the field elements of a length-prefixed hint into the
+// fixed encoding the hint types deserialize from. Vector lengths stay little-endian
+// u64 values; only the elements change width. Hints without field elements are
+// returned unchanged.
+func fixedWidthHint(label string, data []byte) ([]byte, error) {
+	var vectors, depth int
+	switch label {
+	case "stir_answers":
+		vectors, depth = 1, 2
+	case "deferred_weight_evaluations":
+		vectors, depth = 1, 1
+	case "claimed_evaluations":
+		vectors, depth = 2, 1
+	default:
+		return data, nil
+	}
+
+	reader := bytes.NewReader(data)
+	var out bytes.Buffer
+	for range vectors {
+		if err := transcodeFieldVector(reader, &out, depth); err != nil {
+			return nil, fmt.Errorf("%s: %w", label, err)
+		}
+	}
+	if reader.Len() != 0 {
+		return nil, fmt.Errorf("%s: %d trailing bytes", label, reader.Len())
+	}
+	return out.Bytes(), nil
+}
+
+// transcodeFieldVector copies a vector nested depth levels deep from in to out,
+// rewriting its field elements to the fixed encoding.
+func transcodeFieldVector(in *bytes.Reader, out *bytes.Buffer, depth int) error {
+	var length uint64
+	if err := binary.Read(in, binary.LittleEndian, &length); err != nil {
+		return fmt.Errorf("failed to read vector length: %w", err)
+	}
+	if length > uint64(in.Len()) {
+		return fmt.Errorf("vector length %d exceeds the %d remaining bytes", length, in.Len())
+	}
+	_ = binary.Write(out, binary.LittleEndian, length)
+
+	for range length {
+		if depth > 1 {
+			if err := transcodeFieldVector(in, out, depth-1); err != nil {
+				return err
+			}
+			continue
+		}
+		width, err := in.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read field element width: %w", err)
+		}
+		encoded := make([]byte, width)
+		if _, err := io.ReadFull(in, encoded); err != nil {
+			return fmt.Errorf("failed to read field element: %w", err)
+		}
+		value, err := decodeFieldElement(encoded)
+		if err != nil {
+			return err
+		}
+		var fixed [fieldElementBytes]byte
+		for i, limb := range value.Limbs {
+			binary.LittleEndian.PutUint64(fixed[8*i:], limb)
+		}
+		out.Write(fixed[:])
+	}
+	return nil
+}
+
+// decodeFieldElement decodes a little-endian field element of any width up to
+// fieldElementBytes and checks that it is canonical.
+func decodeFieldElement(encoded []byte) (Fp256, error) {
+	if len(encoded) > fieldElementBytes {
+		return Fp256{}, fmt.Errorf("field element has %d bytes, expected at most %d", len(encoded), fieldElementBytes)
+	}
+	var padded [fieldElementBytes]byte
+	copy(padded[:], encoded)
+
+	var value Fp256
+	for i := range value.Limbs {
+		value.Limbs[i] = binary.LittleEndian.Uint64(padded[8*i:])
+	}
+	if err := checkCanonical(value); err != nil {
+		return Fp256{}, err
+	}
+	return value, nil
+}
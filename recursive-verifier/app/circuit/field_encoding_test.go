@@ -0,0 +1,78 @@
+package circuit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestDecodeFieldElement(t *testing.T) {
+	for _, tc := range []struct {
+		encoded []byte
+		want    uint64
+	}{
+		{nil, 0},
+		{[]byte{0x05}, 5},
+		{[]byte{0x01, 0x02}, 0x0201},
+	} {
+		value, err := decodeFieldElement(tc.encoded)
+		if err != nil {
+			t.Errorf("%x rejected: %v", tc.encoded, err)
+			continue
+		}
+		if value != (Fp256{Limbs: [4]uint64{tc.want}}) {
+			t.Errorf("%x decoded to %v, expected %d", tc.encoded, value.Limbs, tc.want)
+		}
+	}
+
+	for name, encoded := range map[string][]byte{
+		"too wide":  make([]byte, fieldElementBytes+1),
+		"unreduced": bytes.Repeat([]byte{0xff}, fieldElementBytes),
+	} {
+		if _, err := decodeFieldElement(encoded); err == nil {
+			t.Errorf("%s field element accepted", name)
+		}
+	}
+}
+
+// lengthPrefixed encodes elements as a length-prefixed vector of length-prefixed
+// field elements.
+func lengthPrefixed(elements ...[]byte) []byte {
+	out := binary.LittleEndian.AppendUint64(nil, uint64(len(elements)))
+	for _, element := range elements {
+		out = append(out, byte(len(element)))
+		out = append(out, element...)
+	}
+	return out
+}
+
+func TestFixedWidthHint(t *testing.T) {
+	data := lengthPrefixed([]byte{0x05}, []byte{0x01, 0x02})
+	got, err := fixedWidthHint("deferred_weight_evaluations", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := binary.LittleEndian.AppendUint64(nil, 2)
+	want = append(want, 0x05)
+	want = append(want, make([]byte, fieldElementBytes-1)...)
+	want = append(want, 0x01, 0x02)
+	want = append(want, make([]byte, fieldElementBytes-2)...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("fixed width hint is %x, expected %x", got, want)
+	}
+
+	if got, err := fixedWidthHint("merkle_proof", data); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("hint without field elements changed to %x, %v", got, err)
+	}
+
+	for name, malformed := range map[string][]byte{
+		"trailing bytes":    append(append([]byte{}, data...), 0x00),
+		"truncated element": data[:len(data)-1],
+		"overlong vector":   binary.LittleEndian.AppendUint64(nil, 3),
+		"unreduced element": lengthPrefixed(bytes.Repeat([]byte{0xff}, fieldElementBytes)),
+	} {
+		if _, err := fixedWidthHint("deferred_weight_evaluations", malformed); err == nil {
+			t.Errorf("hint with %s accepted", name)
+		}
+	}
+}
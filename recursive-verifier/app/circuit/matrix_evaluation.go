@@ -3,9 +3,21 @@ package circuit
 import (
 	"math/big"
 
+	"reilabs/whir-verifier-circuit/app/typeConverters"
+
 	"github.com/consensys/gnark/frontend"
 )
 
+// Storage orders of the sparse R1CS matrices.
+const (
+	// rowMajorLayout is compressed sparse row storage: new_row_indices holds the
+	// offset of each row's first term and col_indices the column of every term.
+	rowMajorLayout = "row_major"
+	// columnMajorLayout is compressed sparse column storage: new_row_indices holds
+	// the offset of each column's first term and col_indices the row of every term.
+	columnMajorLayout = "column_major"
+)
+
 type SparseMatrix struct {
 	Rows       uint64   `json:"num_rows"`
 	Cols       uint64   `json:"num_cols"`
@@ -22,6 +34,12 @@ type InternerAsString struct {
 	Values string `json:"values"`
 }
 
+// R1CS is the inner constraint system the recursive verifier checks satisfiability of.
+//
+// PublicInputs is the number of public entries of the witness z, which follow the
+// constant one at index 0. The WHIR R1CS proof commits to the whole of z, so the
+// public entries are only bound to given values by VerifySpartan, through the public
+// IO statement of PublicInputsStatement.
 type R1CS struct {
 	PublicInputs uint64           `json:"public_inputs"`
 	Witnesses    uint64           `json:"witnesses"`
@@ -30,6 +48,9 @@ type R1CS struct {
 	A            SparseMatrix     `json:"a"`
 	B            SparseMatrix     `json:"b"`
 	C            SparseMatrix     `json:"c"`
+	// Selectors holds the gate selectors when the config's constraint_system is
+	// Plonkish. The matrices then select the wires of each gate.
+	Selectors *PlonkishSelectors `json:"selectors"`
 }
 
 type MatrixCell struct {
@@ -38,6 +59,31 @@ type MatrixCell struct {
 	value  *big.Int
 }
 
+// matrixCells expands a compressed sparse matrix into its non-zero cells, resolving
+// every value through the interner. With columnMajor the outer offsets index columns
+// and the inner indices rows, instead of the other way round.
+func matrixCells(matrix SparseMatrix, interner Interner, columnMajor bool) []MatrixCell {
+	cells := make([]MatrixCell, len(matrix.Values))
+	for i := range len(matrix.RowIndices) {
+		end := len(matrix.Values) - 1
+		if i < len(matrix.RowIndices)-1 {
+			end = int(matrix.RowIndices[i+1] - 1)
+		}
+		for j := int(matrix.RowIndices[i]); j <= end; j++ {
+			row, column := i, int(matrix.ColIndices[j])
+			if columnMajor {
+				row, column = column, row
+			}
+			cells[j] = MatrixCell{
+				row:    row,
+				column: column,
+				value:  typeConverters.LimbsToBigIntMod(interner.Values[matrix.Values[j]].Limbs),
+			}
+		}
+	}
+	return cells
+}
+
 func evaluateR1CSMatrixExtension(api frontend.API, circuit *Circuit, rowRand []frontend.Variable, colRand []frontend.Variable) []frontend.Variable {
 	ansA := frontend.Variable(0)
 	ansB := frontend.Variable(0)
@@ -77,3 +123,67 @@ func calculateEQOverBooleanHypercube(api frontend.API, r []frontend.Variable) []
 
 	return ans
 }
+
+// Statements of the witness WHIR proof, in the order their claimed evaluations and
+// deferred weight evaluations appear. The first three are the evaluations of Az, Bz
+// and Cz at the Spartan sumcheck point.
+const (
+	matrixStatements = 3
+	// publicInputsStatementIndex is the public IO statement, present when the proof
+	// is verified against public inputs.
+	publicInputsStatementIndex = 3
+)
+
+// hidingSpartanStatements is the number of statements of the hiding Spartan WHIR
+// proof, which opens the blinding polynomial at the Spartan sumcheck point.
+const hidingSpartanStatements = 1
+
+// witnessStatements is the number of statements of the witness WHIR proof when it
+// is verified against publicInputs public inputs.
+func witnessStatements(publicInputs int) int {
+	if publicInputs > 0 {
+		return matrixStatements + 1
+	}
+	return matrixStatements
+}
+
+// firstPublicInputIndex is the index of the first public input in z, after the
+// constant one.
+const firstPublicInputIndex = 1
+
+// PublicInputsStatement returns the value and the weight evaluation at point of the
+// public IO statement of the witness WHIR proof. Its weight selects the public
+// entries of z with the powers of challenge,
+//
+//	w_io(j) = challenge^(j-1) for 1 <= j <= len(publicInputs), and 0 otherwise,
+//
+// so its value <w_io, z> = sum_k challenge^k * publicInputs[k] only depends on the
+// public inputs, and its weight evaluation is sum_k challenge^k * eq(1+k, point).
+// challenge is squeezed after the witness is committed, so a witness whose public
+// entries differ from publicInputs matches the value with probability at most
+// len(publicInputs)/|F|.
+func PublicInputsStatement(api frontend.API, publicInputs []frontend.Variable, challenge frontend.Variable, point []frontend.Variable) (frontend.Variable, frontend.Variable) {
+	value := frontend.Variable(0)
+	weightEvaluation := frontend.Variable(0)
+	power := frontend.Variable(1)
+	for k, input := range publicInputs {
+		value = api.Add(value, api.Mul(power, input))
+		weightEvaluation = api.Add(weightEvaluation, api.Mul(power, eqAtIndex(api, firstPublicInputIndex+k, point)))
+		power = api.Mul(power, challenge)
+	}
+	return value, weightEvaluation
+}
+
+// eqAtIndex returns entry index of calculateEQOverBooleanHypercube(api, point), in
+// which point[0] selects the most significant bit of the index.
+func eqAtIndex(api frontend.API, index int, point []frontend.Variable) frontend.Variable {
+	eq := frontend.Variable(1)
+	for i, x := range point {
+		if index>>(len(point)-1-i)&1 == 1 {
+			eq = api.Mul(eq, x)
+		} else {
+			eq = api.Mul(eq, api.Sub(1, x))
+		}
+	}
+	return eq
+}
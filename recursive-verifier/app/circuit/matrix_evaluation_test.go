@@ -0,0 +1,110 @@
+package circuit
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+type publicInputsCircuit struct {
+	PublicInputs     []frontend.Variable
+	Challenge        frontend.Variable
+	Point            []frontend.Variable
+	Value            frontend.Variable
+	WeightEvaluation frontend.Variable
+}
+
+func (c *publicInputsCircuit) Define(api frontend.API) error {
+	value, weightEvaluation := PublicInputsStatement(api, c.PublicInputs, c.Challenge, c.Point)
+	api.AssertIsEqual(value, c.Value)
+	api.AssertIsEqual(weightEvaluation, c.WeightEvaluation)
+	return nil
+}
+
+func TestPublicInputsStatement(t *testing.T) {
+	shape := &publicInputsCircuit{
+		PublicInputs: make([]frontend.Variable, 2),
+		Point:        make([]frontend.Variable, 2),
+	}
+	// z = (1, 3, 7, w) and the statement weights z[1] and z[2] by 1 and 5. With
+	// point[0] the high bit, eq(1, (2, 3)) = (1-2)*3 = -3 and
+	// eq(2, (2, 3)) = 2*(1-3) = -4.
+	honest := &publicInputsCircuit{
+		PublicInputs:     []frontend.Variable{3, 7},
+		Challenge:        5,
+		Point:            []frontend.Variable{2, 3},
+		Value:            3 + 5*7,
+		WeightEvaluation: -3 + 5*-4,
+	}
+	if err := test.IsSolved(shape, honest, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("correct public inputs rejected: %v", err)
+	}
+
+	tampered := *honest
+	tampered.PublicInputs = []frontend.Variable{3, 8}
+	if err := test.IsSolved(shape, &tampered, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("tampered public input accepted")
+	}
+}
+
+// The weight evaluation must pick the same entry of z as the hypercube evaluation
+// the matrix statements use.
+func TestEqAtIndexMatchesTheHypercube(t *testing.T) {
+	point := []frontend.Variable{2, 3, 11}
+	var circuit eqIndexCircuit
+	circuit.Point = point
+	if err := test.IsSolved(&eqIndexCircuit{Point: make([]frontend.Variable, 3)}, &circuit, ecc.BN254.ScalarField()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type eqIndexCircuit struct {
+	Point []frontend.Variable
+}
+
+func (c *eqIndexCircuit) Define(api frontend.API) error {
+	hypercube := calculateEQOverBooleanHypercube(api, c.Point)
+	for index := range hypercube {
+		api.AssertIsEqual(eqAtIndex(api, index, c.Point), hypercube[index])
+	}
+	return nil
+}
+
+func TestVerifySpartanChecksThePublicInputCount(t *testing.T) {
+	err := VerifySpartan(Config{}, R1CS{PublicInputs: 2}, []*big.Int{big.NewInt(3)})
+	if err == nil || !strings.Contains(err.Error(), "1 public inputs given, the r1cs has 2") {
+		t.Fatalf("public input count mismatch not rejected: %v", err)
+	}
+}
+
+func TestMatrixCellsReadsBothLayouts(t *testing.T) {
+	// The 2x3 matrix [[5, 0, 7], [0, 6, 0]], compressed by rows and by columns.
+	interner := Interner{Values: []Fp256{fp256(big.NewInt(5)), fp256(big.NewInt(6)), fp256(big.NewInt(7))}}
+	byRows := SparseMatrix{Rows: 2, Cols: 3, RowIndices: []uint64{0, 2}, ColIndices: []uint64{0, 2, 1}, Values: []uint64{0, 2, 1}}
+	byColumns := SparseMatrix{Rows: 2, Cols: 3, RowIndices: []uint64{0, 1, 2}, ColIndices: []uint64{0, 1, 0}, Values: []uint64{0, 1, 2}}
+	expected := map[[2]int]int64{{0, 0}: 5, {0, 2}: 7, {1, 1}: 6}
+
+	entries := func(cells []MatrixCell) map[[2]int]int64 {
+		out := make(map[[2]int]int64)
+		for _, cell := range cells {
+			out[[2]int{cell.row, cell.column}] = cell.value.Int64()
+		}
+		return out
+	}
+	if got := entries(matrixCells(byRows, interner, false)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("row-major cells = %v, expected %v", got, expected)
+	}
+	if got := entries(matrixCells(byColumns, interner, true)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("column-major cells = %v, expected %v", got, expected)
+	}
+	// Read the wrong way round, the columns come out as rows.
+	transposed := map[[2]int]int64{{0, 0}: 5, {1, 1}: 6, {2, 0}: 7}
+	if got := entries(matrixCells(byColumns, interner, false)); !reflect.DeepEqual(got, transposed) {
+		t.Errorf("column-major matrix read by rows = %v, expected %v", got, transposed)
+	}
+}
@@ -6,11 +6,35 @@ import (
 
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/std/math/uints"
+	skyscraper "github.com/reilabs/gnark-skyscraper"
 )
 
+// merkleCircuit checks the openings of Merkle.Leaves[i] against the Merkle cap
+// Caps[i] for every opened commitment, without any of the algebraic WHIR checks.
+type merkleCircuit struct {
+	Caps   [][]frontend.Variable
+	Merkle Merkle
+}
+
+func (circuit *merkleCircuit) Define(api frontend.API) error {
+	sc := skyscraper.NewSkyscraper(api, 2)
+	uapi, err := uints.New[uints.U64](api)
+	if err != nil {
+		return err
+	}
+	for i, merkleCap := range circuit.Caps {
+		err = verifyMerkleTreeProofs(api, uapi, sc, circuit.Merkle.LeafIndexes[i], circuit.Merkle.Leaves[i], circuit.Merkle.LeafSiblingHashes[i], circuit.Merkle.AuthPaths[i], merkleCap)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newMerkle lays out the Merkle openings of hint as circuit inputs and assigns
+// them.
 func newMerkle(
 	hint Hint,
-	isContainer bool,
 ) Merkle {
 	var totalAuthPath = make([][][]frontend.Variable, len(hint.merklePaths))
 	var totalLeaves = make([][][]frontend.Variable, len(hint.merklePaths))
@@ -32,30 +56,28 @@ func newMerkle(
 
 		totalLeafIndexes[i] = make([]uints.U64, numOfLeavesProved)
 
-		if !isContainer {
-			var authPathsTemp = make([][]KeccakDigest, numOfLeavesProved)
-			var prevPath = merkle_path.AuthPathsSuffixes[0]
-			authPathsTemp[0] = utilities.Reverse(prevPath)
+		var authPathsTemp = make([][]KeccakDigest, numOfLeavesProved)
+		var prevPath = merkle_path.AuthPathsSuffixes[0]
+		authPathsTemp[0] = utilities.Reverse(prevPath)
 
-			for j := range totalAuthPath[i][0] {
-				totalAuthPath[i][0][j] = typeConverters.LittleEndianUint8ToBigInt(authPathsTemp[0][j].KeccakDigest[:])
-			}
+		for j := range totalAuthPath[i][0] {
+			totalAuthPath[i][0][j] = typeConverters.LittleEndianUint8ToBigInt(authPathsTemp[0][j].KeccakDigest[:])
+		}
 
-			for j := 1; j < numOfLeavesProved; j++ {
-				prevPath = utilities.PrefixDecodePath(prevPath, merkle_path.AuthPathsPrefixLengths[j], merkle_path.AuthPathsSuffixes[j])
-				authPathsTemp[j] = utilities.Reverse(prevPath)
-				for z := 0; z < treeHeight; z++ {
-					totalAuthPath[i][j][z] = typeConverters.LittleEndianUint8ToBigInt(authPathsTemp[j][z].KeccakDigest[:])
-				}
+		for j := 1; j < numOfLeavesProved; j++ {
+			prevPath = utilities.PrefixDecodePath(prevPath, merkle_path.AuthPathsPrefixLengths[j], merkle_path.AuthPathsSuffixes[j])
+			authPathsTemp[j] = utilities.Reverse(prevPath)
+			for z := 0; z < treeHeight; z++ {
+				totalAuthPath[i][j][z] = typeConverters.LittleEndianUint8ToBigInt(authPathsTemp[j][z].KeccakDigest[:])
 			}
+		}
 
-			for z := range numOfLeavesProved {
-				totalLeafSiblingHashes[i][z] = typeConverters.LittleEndianUint8ToBigInt(merkle_path.LeafSiblingHashes[z].KeccakDigest[:])
-				totalLeafIndexes[i][z] = uints.NewU64(merkle_path.LeafIndexes[z])
-				for j := range hint.stirAnswers[i][z] {
-					input := hint.stirAnswers[i][z][j]
-					totalLeaves[i][z][j] = typeConverters.LimbsToBigIntMod(input.Limbs)
-				}
+		for z := range numOfLeavesProved {
+			totalLeafSiblingHashes[i][z] = typeConverters.LittleEndianUint8ToBigInt(merkle_path.LeafSiblingHashes[z].KeccakDigest[:])
+			totalLeafIndexes[i][z] = uints.NewU64(merkle_path.LeafIndexes[z])
+			for j := range hint.stirAnswers[i][z] {
+				input := hint.stirAnswers[i][z][j]
+				totalLeaves[i][z][j] = typeConverters.LimbsToBigIntMod(input.Limbs)
 			}
 		}
 	}
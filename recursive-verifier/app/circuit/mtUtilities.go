@@ -1,6 +1,8 @@
 package circuit
 
 import (
+	"fmt"
+
 	"reilabs/whir-verifier-circuit/app/utilities"
 
 	"github.com/consensys/gnark/frontend"
@@ -19,28 +21,19 @@ func initialSumcheck(
 	linearStatementEvaluations [][]frontend.Variable,
 ) (InitialSumcheckData, frontend.Variable, []frontend.Variable, error) {
 
-	initialCombinationRandomness, err := GenerateCombinationRandomness(api, arthur, len(initialOODAnswers)+len(linearStatementEvaluations[0]))
+	initialCombinationRandomness, err := GenerateCombinationRandomness(api, arthur, len(initialOODAnswers)+whirParams.NumStatements, whirParams.CombinationRandomness, whirParams.ChallengeReduction)
 	if err != nil {
 		return InitialSumcheckData{}, nil, nil, err
 	}
 
-	combinedLinearStatementEvaluations := make([]frontend.Variable, len(linearStatementEvaluations[0])) //[0, 1, 2]
-	for evaluationIndex := range len(linearStatementEvaluations[0]) {
-		sum := frontend.Variable(0)
-		multiplier := frontend.Variable(1)
-		for j := range len(linearStatementEvaluations) {
-			sum = api.Add(sum, api.Mul(linearStatementEvaluations[j][evaluationIndex], multiplier))
-			multiplier = api.Mul(multiplier, batchingRandomness)
-		}
-		combinedLinearStatementEvaluations[evaluationIndex] = sum
-	}
-	OODAnswersAndStatmentEvaluations := append(initialOODAnswers, combinedLinearStatementEvaluations...)
-	lastEval := utilities.DotProduct(api, initialCombinationRandomness, OODAnswersAndStatmentEvaluations)
-
-	initialSumcheckFoldingRandomness, lastEval, err := runWhirSumcheckRounds(api, lastEval, arthur, whirParams.FoldingFactorArray[0], 3)
+	statementValues := CombineStatementEvaluations(api, linearStatementEvaluations, batchingRandomness)
+	initialSumcheckFoldingRandomness, initialClaimedSum, lastEval, err := runWhirSumcheckRounds(api, arthur, whirParams.FoldingFactorArray[0], whirParams.SumcheckDegree, whirParams.sumcheckDegreeBound(), whirParams.ChallengeReduction)
 	if err != nil {
 		return InitialSumcheckData{}, nil, nil, err
 	}
+	if err := AssertInitialSumcheckClaim(api, initialOODAnswers, statementValues, initialCombinationRandomness, initialClaimedSum); err != nil {
+		return InitialSumcheckData{}, nil, nil, err
+	}
 
 	return InitialSumcheckData{
 		InitialOODQueries:            initialOODQueries,
@@ -48,15 +41,91 @@ func initialSumcheck(
 	}, lastEval, initialSumcheckFoldingRandomness, nil
 }
 
-func parseBatchedCommitment(arthur gnarkNimue.Arthur, whir_params WHIRParams) (frontend.Variable, frontend.Variable, []frontend.Variable, [][]frontend.Variable, error) {
-	rootHash := make([]frontend.Variable, 1)
-	if err := arthur.FillNextScalars(rootHash); err != nil {
+// InitialSumcheckClaim computes the claim the first WHIR sumcheck starts from, the
+// genesis of the sumcheck chain: the answers to the commitment's OOD samples and the
+// statement evaluations, in that order, combined with the initial combination
+// randomness.
+func InitialSumcheckClaim(api frontend.API, oodAnswers []frontend.Variable, statementEvaluations []frontend.Variable, combinationRandomness []frontend.Variable) (frontend.Variable, error) {
+	if len(combinationRandomness) != len(oodAnswers)+len(statementEvaluations) {
+		return nil, fmt.Errorf("%d combination coefficients for %d OOD answers and %d statement evaluations", len(combinationRandomness), len(oodAnswers), len(statementEvaluations))
+	}
+	claims := make([]frontend.Variable, 0, len(combinationRandomness))
+	claims = append(claims, oodAnswers...)
+	claims = append(claims, statementEvaluations...)
+	return utilities.DotProduct(api, combinationRandomness, claims), nil
+}
+
+// AssertInitialSumcheckClaim asserts that the first WHIR sumcheck starts from the
+// InitialSumcheckClaim of oodAnswers and statementEvaluations: claimedSum, what the
+// first round polynomial sums to over {0, 1}, must equal it. A tampered OOD answer
+// or statement evaluation changes the claim and fails here.
+func AssertInitialSumcheckClaim(api frontend.API, oodAnswers []frontend.Variable, statementEvaluations []frontend.Variable, combinationRandomness []frontend.Variable, claimedSum frontend.Variable) error {
+	claim, err := InitialSumcheckClaim(api, oodAnswers, statementEvaluations, combinationRandomness)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(claimedSum, claim)
+	return nil
+}
+
+// zkBatchSize is the number of polynomials in each batched commitment of the
+// zero-knowledge protocol: the committed polynomial and its blinding polynomial.
+const zkBatchSize = 2
+
+// sharedBatchPoints evaluates every polynomial of a batched commitment at the same
+// points, as WHIR's batched prover does. It is the only batch_evaluation_points
+// mode the circuit verifies.
+const sharedBatchPoints = "shared"
+
+// CombineStatementEvaluations computes the statement values of a batched commitment
+// from the claimed evaluations of its polynomials. With evaluations[j][i] the claim
+// of polynomial j against weight i and B the batching randomness,
+//
+//	value_i = sum_j B^j * evaluations[j][i],
+//
+// which for the witness commitment is value_i = FSums[i] + B * GSums[i].
+// Combining the claims this way relies on every polynomial in the batch being
+// evaluated against the same weights, so that the batch reduces to a single
+// polynomial and a single final point (sharedBatchPoints).
+func CombineStatementEvaluations(api frontend.API, evaluations [][]frontend.Variable, batchingRandomness frontend.Variable) []frontend.Variable {
+	combined := make([]frontend.Variable, len(evaluations[0]))
+	for evaluationIndex := range len(evaluations[0]) {
+		sum := frontend.Variable(0)
+		multiplier := frontend.Variable(1)
+		for j := range len(evaluations) {
+			sum = api.Add(sum, api.Mul(evaluations[j][evaluationIndex], multiplier))
+			multiplier = api.Mul(multiplier, batchingRandomness)
+		}
+		combined[evaluationIndex] = sum
+	}
+	return combined
+}
+
+// AssertSumsEqual asserts that the claimed evaluations fSums and the blinding
+// evaluations gSums have the same sum. Plain Spartan claims carry no such relation.
+// It only holds, and is only enforced, when the R1CS embeds a sum argument (such as
+// a permutation or lookup argument) whose grand sums are split across the two
+// polynomials. The config signals this with sum_argument.
+func AssertSumsEqual(api frontend.API, fSums []frontend.Variable, gSums []frontend.Variable) {
+	fTotal, gTotal := frontend.Variable(0), frontend.Variable(0)
+	for _, value := range fSums {
+		fTotal = api.Add(fTotal, value)
+	}
+	for _, value := range gSums {
+		gTotal = api.Add(gTotal, value)
+	}
+	api.AssertIsEqual(fTotal, gTotal)
+}
+
+func parseBatchedCommitment(api frontend.API, arthur gnarkNimue.Arthur, whir_params WHIRParams) ([]frontend.Variable, frontend.Variable, []frontend.Variable, [][]frontend.Variable, error) {
+	rootHash, err := fillInMerkleCap(arthur, whir_params.MerkleCapSize)
+	if err != nil {
 		return nil, nil, nil, [][]frontend.Variable{}, err
 	}
 	oodPoints := make([]frontend.Variable, 1)
 	oodAnswers := make([][]frontend.Variable, whir_params.BatchSize)
 
-	if err := arthur.FillChallengeScalars(oodPoints); err != nil {
+	if err := squeezeFieldElements(api, arthur, whir_params.OODHashToField, oodPoints); err != nil {
 		return nil, nil, nil, nil, err
 	}
 	for i := range whir_params.BatchSize {
@@ -69,10 +138,10 @@ func parseBatchedCommitment(arthur gnarkNimue.Arthur, whir_params WHIRParams) (f
 	}
 
 	batchingRandomness := make([]frontend.Variable, 1)
-	if err := arthur.FillChallengeScalars(batchingRandomness); err != nil {
+	if err := squeezeChallenges(api, arthur, whir_params.ChallengeReduction, batchingRandomness); err != nil {
 		return nil, 0, nil, nil, err
 	}
-	return rootHash[0], batchingRandomness[0], oodPoints, oodAnswers, nil
+	return rootHash, batchingRandomness[0], oodPoints, oodAnswers, nil
 }
 
 func generateFinalCoefficientsAndRandomnessPoints(api frontend.API, arthur gnarkNimue.Arthur, whir_params WHIRParams, circuit Merkle, uapi *uints.BinaryField[uints.U64], sc *skyscraper.Skyscraper, domainSize int, expDomainGenerator frontend.Variable) ([]frontend.Variable, []frontend.Variable, error) {
@@ -93,6 +162,65 @@ func generateFinalCoefficientsAndRandomnessPoints(api frontend.API, arthur gnark
 	return finalCoefficients, finalRandomnessPoints, nil
 }
 
+// checkBatchedLeafLayout checks that the opened leaves of a batched commitment decode
+// into exactly batchSize polynomials, each contributing foldSize values and
+// evaluated against numStatements statements. Once
+// separated by separateBatchedLeaves, polynomial b occupies
+// leaf[b*foldSize : (b+1)*foldSize]. Polynomial b is weighted
+// by B^b both in rlcBatchedLeaves and when its statement evaluations and OOD answers
+// are combined, so each block must have a matching statement and OOD entry.
+func checkBatchedLeafLayout(leaves [][]frontend.Variable, foldSize int, batchSize int, numStatements int, statementEvaluations [][]frontend.Variable, oodAnswers [][]frontend.Variable) error {
+	if len(statementEvaluations) != batchSize {
+		return fmt.Errorf("batched commitment holds %d polynomials but %d statement evaluation sets were given", batchSize, len(statementEvaluations))
+	}
+	if len(oodAnswers) != batchSize {
+		return fmt.Errorf("batched commitment holds %d polynomials but %d OOD answer sets were given", batchSize, len(oodAnswers))
+	}
+	for b := range statementEvaluations {
+		if len(statementEvaluations[b]) != numStatements {
+			return fmt.Errorf("polynomial %d of the batch has %d statement evaluations, expected %d", b, len(statementEvaluations[b]), numStatements)
+		}
+	}
+	for i := range leaves {
+		if len(leaves[i]) != foldSize*batchSize {
+			return fmt.Errorf("leaf %d of the batched commitment has %d values, expected %d polynomials of %d evaluations", i, len(leaves[i]), batchSize, foldSize)
+		}
+	}
+	return nil
+}
+
+// Orders in which a batched commitment lays out the polynomials in each leaf.
+const (
+	// concatenatedLeafLayout stores the foldSize values of each polynomial one
+	// after the other, as WHIR's batched prover does. This is the default.
+	concatenatedLeafLayout = "concatenated"
+	// interleavedLeafLayout stores the values of all polynomials at each point of
+	// the fold together, leaf[j*batchSize + b] being value j of polynomial b. The
+	// hiding variant uses it to commit the witness and blinding polynomials in a
+	// single tree with both components of each evaluation side by side.
+	interleavedLeafLayout = "interleaved"
+)
+
+// separateBatchedLeaves rewrites the opened leaves of a batched commitment into the
+// concatenated layout the batching gadgets expect. The Merkle openings are checked
+// against the leaves as committed, so only the values used after opening are
+// reordered.
+func separateBatchedLeaves(leaves [][]frontend.Variable, foldSize int, batchSize int, layout string) [][]frontend.Variable {
+	if layout != interleavedLeafLayout {
+		return leaves
+	}
+	separated := make([][]frontend.Variable, len(leaves))
+	for i := range leaves {
+		separated[i] = make([]frontend.Variable, foldSize*batchSize)
+		for j := 0; j < foldSize; j++ {
+			for b := 0; b < batchSize; b++ {
+				separated[i][b*foldSize+j] = leaves[i][j*batchSize+b]
+			}
+		}
+	}
+	return separated
+}
+
 // rlcBatchedLeaves collapses a wide leaf (length foldSize * batchSize) into foldSize via
 // out[j] = sum_{b=0..batchSize-1} B^b * leaf[b*foldSize + j]
 func rlcBatchedLeaves(api frontend.API, leaves [][]frontend.Variable, foldSize int, batchSize int, B frontend.Variable) [][]frontend.Variable {
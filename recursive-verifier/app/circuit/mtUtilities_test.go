@@ -0,0 +1,211 @@
+package circuit
+
+import (
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// statementEvaluationsCircuit combines Evaluations, one set per polynomial of the
+// batch, and checks the result against StatementValues.
+type statementEvaluationsCircuit struct {
+	Evaluations        [][]frontend.Variable
+	BatchingRandomness frontend.Variable
+	StatementValues    []frontend.Variable
+}
+
+func (c *statementEvaluationsCircuit) Define(api frontend.API) error {
+	combined := CombineStatementEvaluations(api, c.Evaluations, c.BatchingRandomness)
+	for i := range combined {
+		api.AssertIsEqual(combined[i], c.StatementValues[i])
+	}
+	return nil
+}
+
+func TestCombineStatementEvaluations(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		evaluations [][]frontend.Variable
+		// values are sum_j 5^j * evaluations[j][i], worked out by hand.
+		values []frontend.Variable
+	}{
+		{"one polynomial", [][]frontend.Variable{{1, 2, 3}}, []frontend.Variable{1, 2, 3}},
+		{"two polynomials", [][]frontend.Variable{{1, 2, 3}, {4, 5, 6}}, []frontend.Variable{21, 27, 33}},
+		{"three polynomials", [][]frontend.Variable{{1, 2, 3}, {4, 5, 6}, {1, 0, 2}}, []frontend.Variable{46, 27, 83}},
+	} {
+		shape := &statementEvaluationsCircuit{
+			Evaluations:     make([][]frontend.Variable, len(tc.evaluations)),
+			StatementValues: make([]frontend.Variable, 3),
+		}
+		for j := range shape.Evaluations {
+			shape.Evaluations[j] = make([]frontend.Variable, 3)
+		}
+		honest := &statementEvaluationsCircuit{Evaluations: tc.evaluations, BatchingRandomness: 5, StatementValues: tc.values}
+		if err := test.IsSolved(shape, honest, ecc.BN254.ScalarField()); err != nil {
+			t.Errorf("%s: combined statement values rejected: %v", tc.name, err)
+		}
+
+		// The last polynomial's evaluations must reach the statement values too.
+		tampered := &statementEvaluationsCircuit{Evaluations: make([][]frontend.Variable, len(tc.evaluations)), BatchingRandomness: 5, StatementValues: tc.values}
+		copy(tampered.Evaluations, tc.evaluations)
+		last := len(tc.evaluations) - 1
+		tampered.Evaluations[last] = []frontend.Variable{tc.evaluations[last][0], tc.evaluations[last][1], 7}
+		if err := test.IsSolved(shape, tampered, ecc.BN254.ScalarField()); err == nil {
+			t.Errorf("%s: tampered evaluation of the last polynomial accepted", tc.name)
+		}
+	}
+}
+
+type initialClaimCircuit struct {
+	OODAnswers            []frontend.Variable
+	StatementEvaluations  []frontend.Variable
+	CombinationRandomness []frontend.Variable
+	ClaimedSum            frontend.Variable
+}
+
+func (c *initialClaimCircuit) Define(api frontend.API) error {
+	return AssertInitialSumcheckClaim(api, c.OODAnswers, c.StatementEvaluations, c.CombinationRandomness, c.ClaimedSum)
+}
+
+func TestAssertInitialSumcheckClaim(t *testing.T) {
+	shape := &initialClaimCircuit{
+		OODAnswers:            make([]frontend.Variable, 1),
+		StatementEvaluations:  make([]frontend.Variable, 2),
+		CombinationRandomness: make([]frontend.Variable, 3),
+	}
+	// 1*7 + 3*2 + 9*5: the OOD answer comes first, then the statement evaluations.
+	honest := &initialClaimCircuit{
+		OODAnswers:            []frontend.Variable{7},
+		StatementEvaluations:  []frontend.Variable{2, 5},
+		CombinationRandomness: []frontend.Variable{1, 3, 9},
+		ClaimedSum:            58,
+	}
+	if err := test.IsSolved(shape, honest, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("initial claim rejected: %v", err)
+	}
+
+	tampered := *honest
+	tampered.OODAnswers = []frontend.Variable{8}
+	if err := test.IsSolved(shape, &tampered, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("tampered OOD answer accepted")
+	}
+
+	short := &initialClaimCircuit{CombinationRandomness: []frontend.Variable{1, 3}, ClaimedSum: 0}
+	if err := test.IsSolved(&initialClaimCircuit{CombinationRandomness: make([]frontend.Variable, 2)}, short, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("combination randomness of the wrong length accepted")
+	}
+}
+
+type sumsEqualCircuit struct {
+	FSums, GSums []frontend.Variable
+}
+
+func (c *sumsEqualCircuit) Define(api frontend.API) error {
+	AssertSumsEqual(api, c.FSums, c.GSums)
+	return nil
+}
+
+func TestAssertSumsEqual(t *testing.T) {
+	shape := &sumsEqualCircuit{FSums: make([]frontend.Variable, 3), GSums: make([]frontend.Variable, 3)}
+	// Only the totals have to agree, not the individual sums.
+	honest := &sumsEqualCircuit{FSums: []frontend.Variable{1, 2, 3}, GSums: []frontend.Variable{4, 0, 2}}
+	if err := test.IsSolved(shape, honest, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("equal sums rejected: %v", err)
+	}
+
+	tampered := &sumsEqualCircuit{FSums: []frontend.Variable{1, 2, 3}, GSums: []frontend.Variable{4, 0, 3}}
+	if err := test.IsSolved(shape, tampered, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("unequal sums accepted")
+	}
+}
+
+// batchedLeavesCircuit separates Leaf, laid out as Layout, and combines its two polynomials
+// with batching randomness 10.
+type batchedLeavesCircuit struct {
+	Layout   string
+	Leaf     []frontend.Variable
+	Combined []frontend.Variable
+}
+
+func (c *batchedLeavesCircuit) Define(api frontend.API) error {
+	separated := separateBatchedLeaves([][]frontend.Variable{c.Leaf}, 2, 2, c.Layout)
+	combined := rlcBatchedLeaves(api, separated, 2, 2, 10)
+	for j := range c.Combined {
+		api.AssertIsEqual(combined[0][j], c.Combined[j])
+	}
+	return nil
+}
+
+func TestSeparateBatchedLeaves(t *testing.T) {
+	leaf := []frontend.Variable{1, 2, 3, 4}
+	// Interleaved, the polynomials are (1, 3) and (2, 4); concatenated, (1, 2) and
+	// (3, 4).
+	interleaved := []frontend.Variable{21, 43}
+	concatenated := []frontend.Variable{31, 42}
+
+	for _, tc := range []struct {
+		layout             string
+		honest, misordered []frontend.Variable
+	}{
+		{interleavedLeafLayout, interleaved, concatenated},
+		{concatenatedLeafLayout, concatenated, interleaved},
+		{"", concatenated, interleaved},
+	} {
+		shape := &batchedLeavesCircuit{Layout: tc.layout, Leaf: make([]frontend.Variable, 4), Combined: make([]frontend.Variable, 2)}
+		honest := &batchedLeavesCircuit{Layout: tc.layout, Leaf: leaf, Combined: tc.honest}
+		if err := test.IsSolved(shape, honest, ecc.BN254.ScalarField()); err != nil {
+			t.Errorf("%q layout: leaf rejected: %v", tc.layout, err)
+		}
+		misordered := &batchedLeavesCircuit{Layout: tc.layout, Leaf: leaf, Combined: tc.misordered}
+		if err := test.IsSolved(shape, misordered, ecc.BN254.ScalarField()); err == nil {
+			t.Errorf("%q layout: leaf accepted in the other layout", tc.layout)
+		}
+	}
+}
+
+func TestCheckBatchedLeafLayoutUsesConfiguredStatementCount(t *testing.T) {
+	leaves := [][]frontend.Variable{make([]frontend.Variable, 4)}
+	oodAnswers := [][]frontend.Variable{{1}, {2}}
+	three := [][]frontend.Variable{{1, 2, 3}, {4, 5, 6}}
+	if err := checkBatchedLeafLayout(leaves, 2, 2, 3, three, oodAnswers); err != nil {
+		t.Fatalf("three statement evaluations per polynomial rejected: %v", err)
+	}
+
+	// A proof that drops the same statement from every polynomial is consistent
+	// across the batch, but not with the statement count of the protocol.
+	two := [][]frontend.Variable{{1, 2}, {4, 5}}
+	if err := checkBatchedLeafLayout(leaves, 2, 2, 3, two, oodAnswers); err == nil {
+		t.Fatal("two statement evaluations per polynomial accepted for three statements")
+	}
+	ragged := [][]frontend.Variable{{1, 2, 3}, {4, 5}}
+	if err := checkBatchedLeafLayout(leaves, 2, 2, 3, ragged, oodAnswers); err == nil {
+		t.Fatal("ragged statement evaluations accepted")
+	}
+}
+
+func TestCheckBatchedLeafLayout(t *testing.T) {
+	statements := [][]frontend.Variable{{1}, {2}}
+	oodAnswers := [][]frontend.Variable{{3}, {4}}
+	leaf := func(width int) [][]frontend.Variable {
+		return [][]frontend.Variable{make([]frontend.Variable, 4), make([]frontend.Variable, width)}
+	}
+	if err := checkBatchedLeafLayout(leaf(4), 2, 2, 1, statements, oodAnswers); err != nil {
+		t.Fatalf("two blocks of two values rejected: %v", err)
+	}
+	for _, tc := range []struct {
+		name                   string
+		leaves                 [][]frontend.Variable
+		statements, oodAnswers [][]frontend.Variable
+	}{
+		{"leaf missing a value", leaf(3), statements, oodAnswers},
+		{"leaf with an extra block", leaf(6), statements, oodAnswers},
+		{"statements of one polynomial", leaf(4), statements[:1], oodAnswers},
+		{"OOD answers of three polynomials", leaf(4), statements, append(oodAnswers, oodAnswers[0])},
+	} {
+		if err := checkBatchedLeafLayout(tc.leaves, 2, 2, 1, tc.statements, tc.oodAnswers); err == nil {
+			t.Errorf("%s accepted", tc.name)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package circuit
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/consensys/gnark/frontend"
+	bits2 "github.com/consensys/gnark/std/math/bits"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/std/permutation/poseidon2"
+	gnarkNimue "github.com/reilabs/gnark-nimue"
+)
+
+// Sponges the Fiat-Shamir transcript can be instantiated with.
+const (
+	// skyscraperTranscript is the Skyscraper sponge ProveKit uses, and the default.
+	skyscraperTranscript = "skyscraper"
+	// poseidon2Transcript is a Poseidon2 sponge over BN254, see poseidon2Width.
+	poseidon2Transcript = "poseidon2"
+)
+
+// Parameters of the Poseidon2 sponge. They are the ones gnark-crypto documents for
+// its BN254 Poseidon2 sponge instance (GetDefaultParameters in
+// gnark-crypto/ecc/bn254/fr/poseidon2: width 3 for a sponge, 6 full and 50 partial
+// rounds), so a prover hashing its transcript with gnark-crypto's native
+// permutation, NewPermutation(3, 6, 50), derives the same challenges. Both derive
+// the round keys from the same parameters.
+const (
+	poseidon2Width           = 3
+	poseidon2Rate            = 2
+	poseidon2FullRounds      = 6
+	poseidon2PartialRounds   = 50
+	poseidon2ChallengeBytes  = 15
+	poseidon2CapacityElement = poseidon2Width - 1
+)
+
+// poseidonSponge is a duplex sponge over the Poseidon2 permutation. Elements are
+// absorbed into, and squeezed from, the rate lanes; the IV is placed in the
+// capacity lane, as the Skyscraper sponge does. The DuplexHash methods cannot
+// return errors, so the first permutation error is kept in err, after which the
+// sponge stops permuting.
+type poseidonSponge struct {
+	permutation *poseidon2.Permutation
+	state       [poseidon2Width]frontend.Variable
+	absorbPos   int
+	squeezePos  int
+	err         error
+}
+
+func (s *poseidonSponge) Initialize(iv [32]byte) {
+	slices.Reverse(iv[:])
+	for i := range s.state {
+		s.state[i] = 0
+	}
+	s.state[poseidon2CapacityElement] = new(big.Int).SetBytes(iv[:])
+	s.absorbPos = 0
+	s.squeezePos = poseidon2Rate
+}
+
+func (s *poseidonSponge) permute() {
+	if s.err != nil {
+		return
+	}
+	if err := s.permutation.Permutation(s.state[:]); err != nil {
+		s.err = fmt.Errorf("poseidon2 permutation failed: %w", err)
+	}
+}
+
+func (s *poseidonSponge) Absorb(input []frontend.Variable) {
+	for len(input) > 0 {
+		if s.absorbPos == poseidon2Rate {
+			s.permute()
+			s.absorbPos = 0
+		}
+		chunkLen := min(len(input), poseidon2Rate-s.absorbPos)
+		copy(s.state[s.absorbPos:], input[:chunkLen])
+		s.absorbPos += chunkLen
+		input = input[chunkLen:]
+	}
+	s.squeezePos = poseidon2Rate
+}
+
+func (s *poseidonSponge) Squeeze(output []frontend.Variable) {
+	for len(output) > 0 {
+		if s.squeezePos == poseidon2Rate {
+			s.permute()
+			s.squeezePos = 0
+			s.absorbPos = 0
+		}
+		chunkLen := min(len(output), poseidon2Rate-s.squeezePos)
+		copy(output, s.state[s.squeezePos:s.squeezePos+chunkLen])
+		s.squeezePos += chunkLen
+		output = output[chunkLen:]
+	}
+}
+
+func (s *poseidonSponge) Ratchet() {
+	s.permute()
+	for i := range poseidon2Rate {
+		s.state[i] = 0
+	}
+	s.squeezePos = poseidon2Rate
+}
+
+func (s *poseidonSponge) PrintState(api frontend.API) {
+	api.Println(fmt.Sprintf("absorbPos %d squeezePos %d", s.absorbPos, s.squeezePos))
+	api.Println(s.state[:]...)
+}
+
+// PoseidonTranscript replays a prover transcript whose Fiat-Shamir sponge is
+// Poseidon2 rather than Skyscraper. Messages are framed as gnark-nimue frames them
+// for the Skyscraper sponge: scalars are absorbed and squeezed as field elements,
+// bytes are absorbed one element per byte, and challenge bytes are taken from the
+// low 15 bytes of squeezed elements.
+type PoseidonTranscript struct {
+	api        frontend.API
+	transcript []uints.U8
+	sponge     *poseidonSponge
+	safe       *gnarkNimue.Safe[frontend.Variable, *poseidonSponge]
+}
+
+// NewPoseidonTranscript returns a transcript checking the operations of io as it
+// reads prover messages from transcript.
+func NewPoseidonTranscript(api frontend.API, io []byte, transcript []uints.U8) (*PoseidonTranscript, error) {
+	permutation, err := poseidon2.NewPoseidon2FromParameters(api, poseidon2Width, poseidon2FullRounds, poseidon2PartialRounds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise poseidon2: %w", err)
+	}
+	return newPoseidonTranscript(api, io, transcript, permutation)
+}
+
+func newPoseidonTranscript(api frontend.API, io []byte, transcript []uints.U8, permutation *poseidon2.Permutation) (*PoseidonTranscript, error) {
+	sponge := &poseidonSponge{permutation: permutation}
+	safe, err := gnarkNimue.NewSafe[frontend.Variable](sponge, io, true)
+	if err != nil {
+		return nil, err
+	}
+	return &PoseidonTranscript{api, transcript, sponge, safe}, nil
+}
+
+// absorb and squeeze run a sponge operation and report a failed permutation.
+func (t *PoseidonTranscript) absorb(in []frontend.Variable) error {
+	if err := t.safe.Absorb(in); err != nil {
+		return err
+	}
+	return t.sponge.err
+}
+
+func (t *PoseidonTranscript) squeeze(out []frontend.Variable) error {
+	if err := t.safe.Squeeze(out); err != nil {
+		return err
+	}
+	return t.sponge.err
+}
+
+func (t *PoseidonTranscript) FillNextBytes(out []uints.U8) error {
+	if len(out) > len(t.transcript) {
+		return fmt.Errorf("transcript has %d bytes left, %d requested", len(t.transcript), len(out))
+	}
+	copy(out, t.transcript)
+	t.transcript = t.transcript[len(out):]
+	for _, b := range out {
+		if err := t.absorb([]frontend.Variable{b.Val}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *PoseidonTranscript) FillChallengeBytes(out []uints.U8) error {
+	tmp := make([]frontend.Variable, 1)
+	for start := 0; start < len(out); start += poseidon2ChallengeBytes {
+		if err := t.FillChallengeScalars(tmp); err != nil {
+			return err
+		}
+		bits := bits2.ToBinary(t.api, tmp[0])
+		for k := 0; k < poseidon2ChallengeBytes && start+k < len(out); k++ {
+			value := frontend.Variable(0)
+			for j := 7; j >= 0; j-- {
+				value = t.api.Add(t.api.Mul(value, 2), bits[8*k+j])
+			}
+			out[start+k] = uints.U8{Val: value}
+		}
+	}
+	return nil
+}
+
+func (t *PoseidonTranscript) FillNextScalars(out []frontend.Variable) error {
+	wordSize := (t.api.Compiler().FieldBitLen() + 7) / 8
+	if len(out)*wordSize > len(t.transcript) {
+		return fmt.Errorf("transcript has %d bytes left, %d scalars requested", len(t.transcript), len(out))
+	}
+	for i := range out {
+		out[i] = frontend.Variable(0)
+		curMul := big.NewInt(1)
+		for _, b := range t.transcript[:wordSize] {
+			out[i] = t.api.Add(out[i], t.api.Mul(b.Val, curMul))
+			curMul.Mul(curMul, big.NewInt(256))
+		}
+		t.transcript = t.transcript[wordSize:]
+	}
+	return t.absorb(out)
+}
+
+func (t *PoseidonTranscript) FillChallengeScalars(out []frontend.Variable) error {
+	return t.squeeze(out)
+}
+
+func (t *PoseidonTranscript) PrintState(api frontend.API) {
+	api.Println(fmt.Sprintf("remaining transcript bytes: %d", len(t.transcript)))
+	t.safe.PrintState(api)
+}
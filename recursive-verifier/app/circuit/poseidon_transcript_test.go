@@ -0,0 +1,136 @@
+package circuit
+
+import (
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	nativePoseidon2 "github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon2"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/std/permutation/poseidon2"
+	"github.com/consensys/gnark/test"
+	gnarkNimue "github.com/reilabs/gnark-nimue"
+)
+
+var poseidonProbeIO = []byte("poseidon\x00A3seed\x00S3out\x00")
+
+// ivRecorder keeps the IV gnark-nimue derives from an IO pattern.
+type ivRecorder struct{ iv [32]byte }
+
+func (r *ivRecorder) Initialize(iv [32]byte)    { r.iv = iv }
+func (*ivRecorder) Absorb([]frontend.Variable)  {}
+func (*ivRecorder) Squeeze([]frontend.Variable) {}
+func (*ivRecorder) Ratchet()                    {}
+func (*ivRecorder) PrintState(frontend.API)     {}
+
+// nativePoseidonSqueeze absorbs inputs into a sponge over gnark-crypto's native
+// Poseidon2 permutation and squeezes count elements: the IV sits reversed in the
+// last lane, the first two lanes are the rate and full rate blocks are overwritten.
+func nativePoseidonSqueeze(t *testing.T, io []byte, inputs []*big.Int, count int) []*big.Int {
+	t.Helper()
+	recorder := &ivRecorder{}
+	if _, err := gnarkNimue.NewSafe[frontend.Variable](recorder, io, true); err != nil {
+		t.Fatal(err)
+	}
+	iv := recorder.iv
+	slices.Reverse(iv[:])
+
+	permutation := nativePoseidon2.NewPermutation(3, 6, 50)
+	var state [3]fr.Element
+	state[2].SetBytes(iv[:])
+	permute := func() {
+		if err := permutation.Permutation(state[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < len(inputs); i += 2 {
+		if i > 0 {
+			permute()
+		}
+		for j := i; j < min(i+2, len(inputs)); j++ {
+			state[j-i].SetBigInt(inputs[j])
+		}
+	}
+	var out []*big.Int
+	for len(out) < count {
+		permute()
+		for j := 0; j < 2 && len(out) < count; j++ {
+			out = append(out, state[j].BigInt(new(big.Int)))
+		}
+	}
+	return out
+}
+
+// poseidonTranscriptCircuit replays poseidonProbeIO and checks the squeezed
+// challenges against Expected. A non-zero Width replaces the permutation.
+type poseidonTranscriptCircuit struct {
+	Width      int
+	Transcript []uints.U8
+	Expected   []frontend.Variable
+}
+
+func (c *poseidonTranscriptCircuit) Define(api frontend.API) error {
+	var transcript *PoseidonTranscript
+	var err error
+	if c.Width == 0 {
+		transcript, err = NewPoseidonTranscript(api, poseidonProbeIO, c.Transcript)
+	} else {
+		var permutation *poseidon2.Permutation
+		if permutation, err = poseidon2.NewPoseidon2FromParameters(api, c.Width, poseidon2FullRounds, poseidon2PartialRounds); err != nil {
+			return err
+		}
+		transcript, err = newPoseidonTranscript(api, poseidonProbeIO, c.Transcript, permutation)
+	}
+	if err != nil {
+		return err
+	}
+	if err := transcript.FillNextScalars(make([]frontend.Variable, 3)); err != nil {
+		return err
+	}
+	out := make([]frontend.Variable, len(c.Expected))
+	if err := transcript.FillChallengeScalars(out); err != nil {
+		return err
+	}
+	for i := range out {
+		api.AssertIsEqual(out[i], c.Expected[i])
+	}
+	return nil
+}
+
+func TestPoseidonTranscriptMatchesNativePermutation(t *testing.T) {
+	var transcript []uints.U8
+	var inputs []*big.Int
+	for _, seed := range []int64{7, 11, 13} {
+		transcript = append(transcript, seedTranscript(seed)...)
+		inputs = append(inputs, big.NewInt(seed))
+	}
+	expected := nativePoseidonSqueeze(t, poseidonProbeIO, inputs, 3)
+
+	shape := &poseidonTranscriptCircuit{Transcript: make([]uints.U8, len(transcript)), Expected: make([]frontend.Variable, 3)}
+	honest := &poseidonTranscriptCircuit{Transcript: transcript, Expected: []frontend.Variable{expected[0], expected[1], expected[2]}}
+	if err := test.IsSolved(shape, honest, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("poseidon2 transcript disagrees with the native permutation: %v", err)
+	}
+
+	tampered := &poseidonTranscriptCircuit{Transcript: transcript, Expected: []frontend.Variable{expected[0], expected[1], new(big.Int).Add(expected[2], big.NewInt(1))}}
+	if err := test.IsSolved(shape, tampered, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("tampered squeeze accepted")
+	}
+}
+
+func TestPoseidonTranscriptReportsPermutationErrors(t *testing.T) {
+	transcript := make([]uints.U8, 3*32)
+	for i := range transcript {
+		transcript[i] = uints.NewU8(0)
+	}
+	shape := &poseidonTranscriptCircuit{Width: 2, Transcript: make([]uints.U8, len(transcript)), Expected: make([]frontend.Variable, 1)}
+	assignment := &poseidonTranscriptCircuit{Width: 2, Transcript: transcript, Expected: []frontend.Variable{0}}
+	err := test.IsSolved(shape, assignment, ecc.BN254.ScalarField())
+	if !errors.Is(err, poseidon2.ErrInvalidSizebuffer) {
+		t.Fatalf("expected the permutation width error, got %v", err)
+	}
+}
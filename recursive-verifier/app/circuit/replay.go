@@ -0,0 +1,85 @@
+package circuit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	cs "github.com/consensys/gnark/constraint/bn254"
+)
+
+// failureReproducer is everything needed to re-run a failed verification: the config
+// holds the proof transcript and its hints, Failure is the error the original
+// verification returned and Kind is its failureKind, which a replay must match.
+type failureReproducer struct {
+	Config  Config `json:"config"`
+	R1CS    R1CS   `json:"r1cs"`
+	Failure string `json:"failure"`
+	Kind    string `json:"kind"`
+}
+
+// reproducibleFailures are the package errors a failure is classified by.
+var reproducibleFailures = []error{
+	ErrFinalRoundCountMismatch,
+	ErrFinalEvaluationMismatch,
+	ErrFinalPolynomialDegree,
+	ErrQueryCountMismatch,
+	ErrPointDimensionMismatch,
+	ErrAuthenticationFailed,
+}
+
+// unsatisfiedConstraintFailure is the kind of a failure where the circuit rejected
+// the proof, whether the native solver or the Groth16 prover found it.
+const unsatisfiedConstraintFailure = "unsatisfied constraint"
+
+// failureKind classifies a verification error so that a replay matches it however it
+// was reached: by the package error it wraps, as an unsatisfied constraint, or else
+// by the first line of its message. gnark appends stack traces to the messages of
+// unsatisfied constraints, which differ between runs and between backends.
+func failureKind(err error) string {
+	for _, sentinel := range reproducibleFailures {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	var unsatisfied *cs.UnsatisfiedConstraintError
+	if errors.As(err, &unsatisfied) {
+		return unsatisfiedConstraintFailure
+	}
+	firstLine, _, _ := strings.Cut(err.Error(), "\n")
+	return firstLine
+}
+
+// DumpFailure serializes the inputs of a failed verification and the error it
+// failed with, verifyErr, into a single blob that ReplayFailure re-runs.
+func DumpFailure(config Config, r1cs R1CS, verifyErr error) ([]byte, error) {
+	if verifyErr == nil {
+		return nil, errors.New("no verification failure to dump")
+	}
+	return json.Marshal(failureReproducer{config, r1cs, verifyErr.Error(), failureKind(verifyErr)})
+}
+
+// ReplayFailure re-runs the verification recorded by DumpFailure natively and
+// returns the error it fails with. If the replayed verification does not fail with
+// an error of the recorded kind, the result wraps ErrFailureNotReproduced. A
+// recorded final evaluation mismatch is replayed with WithMismatchReport, which is
+// the only way NativeVerify reports one.
+func ReplayFailure(blob []byte) error {
+	var reproducer failureReproducer
+	if err := json.Unmarshal(blob, &reproducer); err != nil {
+		return fmt.Errorf("failed to unmarshal reproducer: %w", err)
+	}
+	var opts []NativeOption
+	if reproducer.Kind == ErrFinalEvaluationMismatch.Error() {
+		opts = append(opts, WithMismatchReport())
+	}
+	err := NativeVerify(reproducer.Config, reproducer.R1CS, opts...)
+	if err == nil {
+		return fmt.Errorf("%w: verification passed, recorded failure was %q", ErrFailureNotReproduced, reproducer.Failure)
+	}
+	if failureKind(err) != reproducer.Kind {
+		return fmt.Errorf("%w: got %q, recorded failure was %q", ErrFailureNotReproduced, err, reproducer.Failure)
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package circuit
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	cs "github.com/consensys/gnark/constraint/bn254"
+)
+
+func TestReplayFailureReproducesTheRecordedKind(t *testing.T) {
+	verifyErr := NativeVerify(Config{}, R1CS{})
+	if verifyErr == nil {
+		t.Fatal("empty config verified")
+	}
+
+	for _, recorded := range []error{
+		verifyErr,
+		// The message of a recorded failure can carry a trace the replay does not.
+		fmt.Errorf("%s\n\tat some/file.go:12", verifyErr),
+	} {
+		blob, err := DumpFailure(Config{}, R1CS{}, recorded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ReplayFailure(blob); errors.Is(err, ErrFailureNotReproduced) {
+			t.Fatalf("failure %q not reproduced: %v", recorded, err)
+		}
+	}
+
+	blob, err := DumpFailure(Config{}, R1CS{}, ErrQueryCountMismatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ReplayFailure(blob); !errors.Is(err, ErrFailureNotReproduced) {
+		t.Fatalf("replay of a different failure returned %v", err)
+	}
+}
+
+func TestFailureKind(t *testing.T) {
+	unsatisfied := fmt.Errorf("verification failed: %w", &cs.UnsatisfiedConstraintError{CID: 3, Err: errors.New("9 == 10")})
+	for _, tc := range []struct {
+		err  error
+		kind string
+	}{
+		{fmt.Errorf("witness hints: %w: round 1", ErrQueryCountMismatch), ErrQueryCountMismatch.Error()},
+		{unsatisfied, unsatisfiedConstraintFailure},
+		{errors.New("invalid config\nstack"), "invalid config"},
+	} {
+		if kind := failureKind(tc.err); kind != tc.kind {
+			t.Errorf("failureKind(%q) = %q, expected %q", tc.err, kind, tc.kind)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package circuit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	gnarkNimue "github.com/reilabs/gnark-nimue"
+	arkSerialize "github.com/reilabs/go-ark-serialize"
+)
+
+// finalCoefficientsLabel is the IO pattern label under which each WHIR proof absorbs
+// the coefficients of its final folded polynomial.
+const finalCoefficientsLabel = "final_coeffs"
+
+// sumcheckPolynomialLabel is the IO pattern label of the round polynomials of the
+// WHIR folding sumchecks.
+const sumcheckPolynomialLabel = "sumcheck_poly"
+
+// spartanSumcheckPolynomialLabel is the IO pattern label of the round polynomials of
+// the Spartan sumcheck.
+const spartanSumcheckPolynomialLabel = "Sumcheck Polynomials"
+
+// merkleDigestLabel is the IO pattern label under which every Merkle root (or cap) is
+// absorbed, both for the initial commitments and for each WHIR round.
+const merkleDigestLabel = "merkle_digest"
+
+// transcriptFinalStateLabel is the IO pattern label of the squeeze that ends a
+// transcript carrying a final state tag.
+const transcriptFinalStateLabel = "transcript_final_state"
+
+// protocolDomainSeparator is the session label ("🌪️") that ProveKit's
+// WhirR1CSScheme starts its IO pattern with. The sponge is initialised with a hash
+// of the whole IO pattern, so this label is what binds the transcript to the
+// protocol rather than to some other construction sharing the same sponge.
+const protocolDomainSeparator = "\U0001F32A\uFE0F"
+
+// parseIOPattern parses the IO pattern of config and rejects patterns that were not
+// produced for the ProveKit WHIR R1CS protocol.
+func parseIOPattern(config Config) (gnarkNimue.IOPattern, error) {
+	io := gnarkNimue.IOPattern{}
+	if err := io.Parse([]byte(config.IOPattern)); err != nil {
+		return gnarkNimue.IOPattern{}, fmt.Errorf("failed to parse IO pattern: %w", err)
+	}
+	if string(io.DomainSeparator) != protocolDomainSeparator {
+		return gnarkNimue.IOPattern{}, fmt.Errorf("transcript is initialised for protocol %q, expected %q", io.DomainSeparator, protocolDomainSeparator)
+	}
+	return io, nil
+}
+
+// walkTranscript splits a prover transcript according to the IO pattern and calls
+// visit, in order, with the payload of every hint and every absorbed chunk. Hints
+// are prefixed with their little-endian u32 length, absorbed scalars take 32 bytes
+// each and the PoW nonce is absorbed as raw bytes.
+func walkTranscript(io gnarkNimue.IOPattern, transcript []byte, visit func(op gnarkNimue.Op, data []byte) error) error {
+	var pointer uint64
+
+	for _, op := range io.Ops {
+		switch op.Kind {
+		case gnarkNimue.Hint:
+			if pointer+4 > uint64(len(transcript)) {
+				return fmt.Errorf("insufficient bytes for hint length")
+			}
+			hintLen := binary.LittleEndian.Uint32(transcript[pointer : pointer+4])
+			start := pointer + 4
+			end := start + uint64(hintLen)
+
+			if end > uint64(len(transcript)) {
+				return fmt.Errorf("insufficient bytes for merkle proof")
+			}
+
+			if err := visit(op, transcript[start:end]); err != nil {
+				return err
+			}
+			pointer = end
+
+		case gnarkNimue.Absorb:
+			start := pointer
+			if string(op.Label) == "pow-nonce" {
+				pointer += op.Size
+			} else {
+				pointer += op.Size * 32
+			}
+
+			if pointer > uint64(len(transcript)) {
+				return fmt.Errorf("absorb exceeds transcript length")
+			}
+
+			if err := visit(op, transcript[start:pointer]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// finalSumcheckRoundCounts returns, for every WHIR proof in io and in transcript
+// order, the number of sumcheck polynomials sent in its final phase. That phase
+// follows the answers to the final queries and runs until the next hint or the end
+// of the pattern.
+func finalSumcheckRoundCounts(io gnarkNimue.IOPattern) []int {
+	var counts []int
+	inFinalPhase, queriesAnswered := false, false
+
+	for _, op := range io.Ops {
+		switch {
+		case op.Kind == gnarkNimue.Absorb && string(op.Label) == finalCoefficientsLabel:
+			counts = append(counts, 0)
+			inFinalPhase, queriesAnswered = true, false
+		case !inFinalPhase:
+		case op.Kind == gnarkNimue.Hint:
+			if queriesAnswered {
+				inFinalPhase = false
+			} else if string(op.Label) == "stir_answers" {
+				queriesAnswered = true
+			}
+		case queriesAnswered && op.Kind == gnarkNimue.Absorb && string(op.Label) == sumcheckPolynomialLabel:
+			counts[len(counts)-1]++
+		}
+	}
+	return counts
+}
+
+// checkFinalSumcheckRounds checks that the hiding Spartan and the witness WHIR proofs
+// in io each run exactly the number of final sumcheck rounds their configuration
+// implies.
+func checkFinalSumcheckRounds(config Config, io gnarkNimue.IOPattern) error {
+	expected := []int{
+		config.WHIRConfigHidingSpartan.finalSumcheckRounds(),
+		config.WHIRConfigWitness.finalSumcheckRounds(),
+	}
+	counts := finalSumcheckRoundCounts(io)
+	if len(counts) != len(expected) {
+		return fmt.Errorf("IO pattern has %d WHIR proofs, expected %d", len(counts), len(expected))
+	}
+	for i, name := range []string{"whir_config_hiding_spartan", "whir_config_witness"} {
+		if counts[i] != expected[i] {
+			return fmt.Errorf("%w: %s proof has %d final sumcheck rounds, expected %d", ErrFinalRoundCountMismatch, name, counts[i], expected[i])
+		}
+	}
+	return nil
+}
+
+// checkFinalPolynomialDegrees checks that the final polynomial of the hiding Spartan
+// and the witness WHIR proofs in io has at most 2^finalSumcheckRounds coefficients,
+// one per point of the hypercube the final sumcheck runs over. A final polynomial
+// with more coefficients is not of the degree the low-degree guarantee covers.
+func checkFinalPolynomialDegrees(config Config, io gnarkNimue.IOPattern) error {
+	configs := []WHIRConfig{config.WHIRConfigHidingSpartan, config.WHIRConfigWitness}
+	names := []string{"whir_config_hiding_spartan", "whir_config_witness"}
+	proof := 0
+	for _, op := range io.Ops {
+		if op.Kind != gnarkNimue.Absorb || string(op.Label) != finalCoefficientsLabel {
+			continue
+		}
+		if proof >= len(configs) {
+			return fmt.Errorf("IO pattern has more than %d WHIR proofs", len(configs))
+		}
+		bound := uint64(1) << configs[proof].finalSumcheckRounds()
+		if op.Size > bound {
+			return fmt.Errorf("%w: %s proof sends %d final coefficients, expected at most %d", ErrFinalPolynomialDegree, names[proof], op.Size, bound)
+		}
+		proof++
+	}
+	return nil
+}
+
+// checkTranscriptFinalState checks that, when config claims a final transcript
+// state, io ends with the single-scalar transcriptFinalStateLabel squeeze the
+// circuit reproduces it with. The prover has to declare that squeeze in its IO
+// pattern and perform it after everything else: the state is the challenge
+// squeezed once the whole proof has been absorbed, and a squeeze anywhere earlier
+// would leave the operations after it unbound.
+func checkTranscriptFinalState(config Config, io gnarkNimue.IOPattern) error {
+	if config.TranscriptFinalState == "" {
+		return nil
+	}
+	if len(io.Ops) == 0 {
+		return fmt.Errorf("IO pattern is empty, expected it to end with a %q squeeze", transcriptFinalStateLabel)
+	}
+	last := io.Ops[len(io.Ops)-1]
+	if last.Kind != gnarkNimue.Squeeze || string(last.Label) != transcriptFinalStateLabel || last.Size != 1 {
+		return fmt.Errorf("IO pattern ends with %s %d %q, expected a squeeze of 1 %q for transcript_final_state", last.Kind, last.Size, last.Label, transcriptFinalStateLabel)
+	}
+	return nil
+}
+
+// whirSumcheckRoundCounts returns, for every WHIR proof in io and in transcript
+// order, the number of folding sumcheck polynomials it sends, those of its final
+// phase included. A proof ends with its final phase, as in
+// finalSumcheckRoundCounts.
+func whirSumcheckRoundCounts(io gnarkNimue.IOPattern) []int {
+	var counts []int
+	proof := 0
+	inFinalPhase, queriesAnswered := false, false
+
+	for _, op := range io.Ops {
+		switch {
+		case op.Kind == gnarkNimue.Absorb && string(op.Label) == finalCoefficientsLabel:
+			inFinalPhase, queriesAnswered = true, false
+		case inFinalPhase && op.Kind == gnarkNimue.Hint:
+			if queriesAnswered {
+				inFinalPhase = false
+				proof++
+			} else if string(op.Label) == "stir_answers" {
+				queriesAnswered = true
+			}
+		case op.Kind == gnarkNimue.Absorb && string(op.Label) == sumcheckPolynomialLabel:
+			for len(counts) <= proof {
+				counts = append(counts, 0)
+			}
+			counts[proof]++
+		}
+	}
+	return counts
+}
+
+// checkSpartanSumcheckRounds checks that io sends exactly log_num_constraints
+// Spartan sumcheck round polynomials, one per variable of the constraint index, so
+// a truncated or padded Spartan sumcheck is rejected before the circuit is built.
+// ProveKit has no inner Spartan sumcheck over the witness index: the matrices are
+// evaluated at the point the witness WHIR sumcheck folds to, so that sumcheck must
+// run exactly log_num_variables rounds.
+func checkSpartanSumcheckRounds(config Config, io gnarkNimue.IOPattern) error {
+	rounds := 0
+	for _, op := range io.Ops {
+		if op.Kind == gnarkNimue.Absorb && string(op.Label) == spartanSumcheckPolynomialLabel {
+			rounds++
+		}
+	}
+	if rounds != config.LogNumConstraints {
+		return fmt.Errorf("IO pattern has %d Spartan sumcheck rounds, expected log_num_constraints = %d", rounds, config.LogNumConstraints)
+	}
+
+	// The hiding Spartan proof comes first, then the witness one.
+	witnessRounds := 0
+	if counts := whirSumcheckRoundCounts(io); len(counts) > 1 {
+		witnessRounds = counts[1]
+	}
+	if witnessRounds != config.LogNumVariables {
+		return fmt.Errorf("IO pattern has %d witness WHIR sumcheck rounds, expected log_num_variables = %d", witnessRounds, config.LogNumVariables)
+	}
+	return nil
+}
+
+// roundSchedules returns, for every WHIR proof in io and each of its rounds, whether
+// the round opens the current polynomial (its "stir_answers" hint) before
+// absorbing the commitment to the next folded one. rounds holds the number of
+// rounds of each proof. The last 2*rounds commitments and openings before a proof's
+// final coefficients must pair up as commit-then-open or open-then-commit, one pair
+// per round.
+func roundSchedules(io gnarkNimue.IOPattern, rounds []int) ([][]bool, error) {
+	var schedules [][]bool
+	var events []byte
+	for _, op := range io.Ops {
+		switch {
+		case op.Kind == gnarkNimue.Absorb && string(op.Label) == merkleDigestLabel:
+			events = append(events, 'c')
+		case op.Kind == gnarkNimue.Hint && string(op.Label) == "stir_answers":
+			events = append(events, 'o')
+		case op.Kind == gnarkNimue.Absorb && string(op.Label) == finalCoefficientsLabel:
+			proof := len(schedules)
+			if proof >= len(rounds) {
+				return nil, fmt.Errorf("IO pattern has more than %d WHIR proofs", len(rounds))
+			}
+			n := rounds[proof]
+			if len(events) < 2*n {
+				return nil, fmt.Errorf("WHIR proof %d has %d commitments and openings, expected at least %d", proof, len(events), 2*n)
+			}
+			tail := events[len(events)-2*n:]
+			schedule := make([]bool, n)
+			for r := range n {
+				switch string(tail[2*r : 2*r+2]) {
+				case "co":
+				case "oc":
+					schedule[r] = true
+				default:
+					return nil, fmt.Errorf("WHIR proof %d round %d does not commit once and open once", proof, r)
+				}
+			}
+			schedules = append(schedules, schedule)
+			events = nil
+		}
+	}
+	if len(schedules) != len(rounds) {
+		return nil, fmt.Errorf("IO pattern has %d WHIR proofs, expected %d", len(schedules), len(rounds))
+	}
+	return schedules, nil
+}
+
+// checkSpartanSumcheckDegree checks that every Spartan sumcheck round polynomial in
+// io has the spartanSumcheckDegree()+1 coefficients the circuit reads.
+func checkSpartanSumcheckDegree(config Config, io gnarkNimue.IOPattern) error {
+	expected := uint64(config.spartanSumcheckDegree() + 1)
+	for _, op := range io.Ops {
+		if op.Kind == gnarkNimue.Absorb && string(op.Label) == spartanSumcheckPolynomialLabel && op.Size != expected {
+			return fmt.Errorf("IO pattern sends Spartan sumcheck polynomials with %d coefficients, expected %d for degree %d", op.Size, expected, config.spartanSumcheckDegree())
+		}
+	}
+	return nil
+}
+
+// transcriptData holds a prover transcript split into the bytes absorbed by the
+// sponge and the decoded prover hints.
+type transcriptData struct {
+	absorbed           []byte
+	merkleCaps         [][][]byte
+	merklePaths        []MultiPath[KeccakDigest]
+	stirAnswers        [][][]Fp256
+	deferred           []Fp256
+	claimedEvaluations ClaimedEvaluations
+}
+
+// decodeTranscript walks transcript according to io, collecting the absorbed bytes
+// (which the in-circuit sponge replays) and deserializing every hint. The nodes of
+// every absorbed Merkle cap (a single root unless a cap height is configured) are
+// additionally recorded in transcript order. fieldEncoding is the serialization of
+// the field elements inside hints; absorbed scalars always take 32 bytes, as the
+// sponge reads them.
+func decodeTranscript(io gnarkNimue.IOPattern, transcript []byte, fieldEncoding string) (transcriptData, error) {
+	var result transcriptData
+
+	err := walkTranscript(io, transcript, func(op gnarkNimue.Op, data []byte) error {
+		if op.Kind == gnarkNimue.Absorb {
+			result.absorbed = append(result.absorbed, data...)
+			if string(op.Label) == merkleDigestLabel {
+				var merkleCap [][]byte
+				for i := 0; i+32 <= len(data); i += 32 {
+					merkleCap = append(merkleCap, data[i:i+32])
+				}
+				result.merkleCaps = append(result.merkleCaps, merkleCap)
+			}
+			return nil
+		}
+
+		var err error
+		if fieldEncoding == lengthPrefixedFieldEncoding {
+			if data, err = fixedWidthHint(string(op.Label), data); err != nil {
+				return err
+			}
+		}
+		switch string(op.Label) {
+		case "merkle_proof":
+			var path MultiPath[KeccakDigest]
+			_, err = arkSerialize.CanonicalDeserializeWithMode(
+				bytes.NewReader(data),
+				&path,
+				false, false,
+			)
+			result.merklePaths = append(result.merklePaths, path)
+
+		case "stir_answers":
+			var stirAnswersTemporary [][]Fp256
+			_, err = arkSerialize.CanonicalDeserializeWithMode(
+				bytes.NewReader(data),
+				&stirAnswersTemporary,
+				false, false,
+			)
+			result.stirAnswers = append(result.stirAnswers, stirAnswersTemporary)
+
+		case "deferred_weight_evaluations":
+			var deferredTemporary []Fp256
+			_, err = arkSerialize.CanonicalDeserializeWithMode(
+				bytes.NewReader(data),
+				&deferredTemporary,
+				false, false,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to deserialize deferred hint: %w", err)
+			}
+			result.deferred = append(result.deferred, deferredTemporary...)
+		case "claimed_evaluations":
+			_, err = arkSerialize.CanonicalDeserializeWithMode(
+				bytes.NewReader(data),
+				&result.claimedEvaluations,
+				false, false,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to deserialize claimed_evaluations: %w", err)
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to deserialize merkle proof: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return transcriptData{}, err
+	}
+	return result, nil
+}
+
+// FinalFoldedPolynomials returns the coefficients of the final folded polynomial of
+// every WHIR proof in the transcript, in transcript order (the hiding Spartan proof
+// first, then the witness proof). Coefficients are in the multilinear order consumed
+// by MultivarPoly when the final polynomial is evaluated.
+func FinalFoldedPolynomials(config Config) ([][]Fp256, error) {
+	io, err := parseIOPattern(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var polynomials [][]Fp256
+	err = walkTranscript(io, config.Transcript, func(op gnarkNimue.Op, data []byte) error {
+		if op.Kind != gnarkNimue.Absorb || string(op.Label) != finalCoefficientsLabel {
+			return nil
+		}
+		polynomials = append(polynomials, absorbedScalars(data))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(polynomials) == 0 {
+		return nil, fmt.Errorf("IO pattern has no %q absorption", finalCoefficientsLabel)
+	}
+	return polynomials, nil
+}
+
+// absorbedScalars decodes absorbed field elements, each sent as 32 little-endian bytes.
+func absorbedScalars(data []byte) []Fp256 {
+	scalars := make([]Fp256, len(data)/32)
+	for i := range scalars {
+		for j := range scalars[i].Limbs {
+			scalars[i].Limbs[j] = binary.LittleEndian.Uint64(data[32*i+8*j:])
+		}
+	}
+	return scalars
+}
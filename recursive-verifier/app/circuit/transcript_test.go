@@ -0,0 +1,269 @@
+package circuit
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	gnarkNimue "github.com/reilabs/gnark-nimue"
+)
+
+func TestCheckTranscriptFinalState(t *testing.T) {
+	absorb := gnarkNimue.Op{Kind: gnarkNimue.Absorb, Label: []byte(merkleDigestLabel), Size: 1}
+	finalState := gnarkNimue.Op{Kind: gnarkNimue.Squeeze, Label: []byte(transcriptFinalStateLabel), Size: 1}
+	claimed := Config{TranscriptFinalState: "7"}
+
+	for _, tc := range []struct {
+		name   string
+		config Config
+		ops    []gnarkNimue.Op
+		valid  bool
+	}{
+		{"no claimed state", Config{}, []gnarkNimue.Op{absorb}, true},
+		{"trailing squeeze", claimed, []gnarkNimue.Op{absorb, finalState}, true},
+		{"empty pattern", claimed, nil, false},
+		{"missing squeeze", claimed, []gnarkNimue.Op{absorb}, false},
+		{"squeeze before the end", claimed, []gnarkNimue.Op{finalState, absorb}, false},
+		{"squeeze of two", claimed, []gnarkNimue.Op{absorb, {Kind: gnarkNimue.Squeeze, Label: finalState.Label, Size: 2}}, false},
+	} {
+		err := checkTranscriptFinalState(tc.config, gnarkNimue.IOPattern{Ops: tc.ops})
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: checkTranscriptFinalState returned %v", tc.name, err)
+		}
+	}
+}
+
+// spartanIO returns the ops of a pattern with spartan Spartan sumcheck rounds, a
+// hiding WHIR proof with one sumcheck round and, after the claimed evaluations
+// ProveKit sends between the two, a witness WHIR proof with witness sumcheck
+// rounds, split across a folding round and the final phase.
+func spartanIO(spartan int, witness int) gnarkNimue.IOPattern {
+	absorb := func(label string) gnarkNimue.Op {
+		return gnarkNimue.Op{Kind: gnarkNimue.Absorb, Label: []byte(label), Size: 1}
+	}
+	hint := func(label string) gnarkNimue.Op {
+		return gnarkNimue.Op{Kind: gnarkNimue.Hint, Label: []byte(label)}
+	}
+	var ops []gnarkNimue.Op
+	for range spartan {
+		ops = append(ops, absorb(spartanSumcheckPolynomialLabel))
+	}
+	ops = append(ops, absorb(sumcheckPolynomialLabel), absorb(finalCoefficientsLabel), hint("merkle_proof"), hint("stir_answers"), hint("claimed_evaluations"))
+	for i := range witness {
+		if i == witness/2 {
+			ops = append(ops, absorb(finalCoefficientsLabel), hint("merkle_proof"), hint("stir_answers"))
+		}
+		ops = append(ops, absorb(sumcheckPolynomialLabel))
+	}
+	ops = append(ops, hint("deferred_weight_evaluations"))
+	return gnarkNimue.IOPattern{Ops: ops}
+}
+
+func TestCheckSpartanSumcheckRounds(t *testing.T) {
+	config := Config{LogNumConstraints: 3, LogNumVariables: 4}
+	if counts := whirSumcheckRoundCounts(spartanIO(3, 4)); len(counts) != 2 || counts[0] != 1 || counts[1] != 4 {
+		t.Fatalf("whirSumcheckRoundCounts = %v, expected [1 4]", counts)
+	}
+	if err := checkSpartanSumcheckRounds(config, spartanIO(3, 4)); err != nil {
+		t.Fatalf("matching round counts rejected: %v", err)
+	}
+	for _, tc := range []struct {
+		name             string
+		spartan, witness int
+	}{
+		{"missing Spartan round", 2, 4},
+		{"extra Spartan round", 4, 4},
+		{"missing witness WHIR round", 3, 3},
+		{"extra witness WHIR round", 3, 5},
+	} {
+		if err := checkSpartanSumcheckRounds(config, spartanIO(tc.spartan, tc.witness)); err == nil {
+			t.Errorf("%s accepted", tc.name)
+		}
+	}
+}
+
+func TestCheckSpartanSumcheckDegree(t *testing.T) {
+	polynomial := func(size uint64) gnarkNimue.IOPattern {
+		return gnarkNimue.IOPattern{Ops: []gnarkNimue.Op{{Kind: gnarkNimue.Absorb, Label: []byte(spartanSumcheckPolynomialLabel), Size: size}}}
+	}
+	for _, tc := range []struct {
+		name   string
+		degree int
+		size   uint64
+		valid  bool
+	}{
+		{"default degree", 0, 4, true},
+		{"default degree with a quartic", 0, 5, false},
+		{"configured quartic", plonkishSpartanSumcheckDegree, 5, true},
+		{"configured quartic with a cubic", plonkishSpartanSumcheckDegree, 4, false},
+	} {
+		err := checkSpartanSumcheckDegree(Config{SpartanSumcheckDegree: tc.degree}, polynomial(tc.size))
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: checkSpartanSumcheckDegree returned %v", tc.name, err)
+		}
+	}
+}
+
+// scalarBytes encodes values as the 32-byte little-endian scalars a transcript
+// absorbs.
+func scalarBytes(values ...uint64) []byte {
+	var out []byte
+	for _, value := range values {
+		word := make([]byte, 32)
+		binary.LittleEndian.PutUint64(word, value)
+		out = append(out, word...)
+	}
+	return out
+}
+
+func TestFinalFoldedPolynomials(t *testing.T) {
+	config := Config{
+		IOPattern:  protocolDomainSeparator + "\x00A1merkle_digest\x00A2final_coeffs\x00S1folding_randomness\x00A1final_coeffs\x00",
+		Transcript: scalarBytes(9, 3, 5, 7),
+	}
+	polynomials, err := FinalFoldedPolynomials(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]Fp256{{{Limbs: [4]uint64{3}}, {Limbs: [4]uint64{5}}}, {{Limbs: [4]uint64{7}}}}
+	if !reflect.DeepEqual(polynomials, expected) {
+		t.Fatalf("FinalFoldedPolynomials = %v, expected %v", polynomials, expected)
+	}
+
+	config.Transcript = config.Transcript[:3*32]
+	if _, err := FinalFoldedPolynomials(config); err == nil {
+		t.Error("truncated transcript accepted")
+	}
+	config.IOPattern = protocolDomainSeparator + "\x00A1merkle_digest\x00"
+	if _, err := FinalFoldedPolynomials(config); err == nil {
+		t.Error("IO pattern without final coefficients accepted")
+	}
+}
+
+func TestParseIOPatternChecksTheDomainSeparator(t *testing.T) {
+	io, err := parseIOPattern(Config{IOPattern: protocolDomainSeparator + "\x00A1merkle_digest\x00"})
+	if err != nil {
+		t.Fatalf("ProveKit IO pattern rejected: %v", err)
+	}
+	if len(io.Ops) != 1 || string(io.Ops[0].Label) != "merkle_digest" {
+		t.Fatalf("parsed ops %v", io.Ops)
+	}
+
+	// A transcript of another protocol, or of ProveKit's label without its
+	// variation selector, must not be replayed as a ProveKit proof.
+	for _, separator := range []string{"whir", "\U0001F32A"} {
+		_, err := parseIOPattern(Config{IOPattern: separator + "\x00A1merkle_digest\x00"})
+		if err == nil || !strings.Contains(err.Error(), "is initialised for protocol") {
+			t.Errorf("domain separator %q: parseIOPattern returned %v", separator, err)
+		}
+	}
+}
+
+func TestCheckFinalSumcheckRounds(t *testing.T) {
+	// The hiding proof ends at the claimed evaluations right after its final
+	// queries; the witness proof runs the sumcheck rounds after its final queries.
+	if counts := finalSumcheckRoundCounts(spartanIO(0, 4)); !reflect.DeepEqual(counts, []int{0, 2}) {
+		t.Fatalf("finalSumcheckRoundCounts = %v, expected [0 2]", counts)
+	}
+
+	// 8 variables folded by 4 leave no final rounds, 6 leave two.
+	config := Config{WHIRConfigHidingSpartan: WHIRConfig{NVars: 8, FoldingFactor: []int{4}}, WHIRConfigWitness: WHIRConfig{NVars: 6, FoldingFactor: []int{4}}}
+	if err := checkFinalSumcheckRounds(config, spartanIO(0, 4)); err != nil {
+		t.Fatalf("matching final rounds rejected: %v", err)
+	}
+	for _, witness := range []int{2, 6} {
+		if err := checkFinalSumcheckRounds(config, spartanIO(0, witness)); !errors.Is(err, ErrFinalRoundCountMismatch) {
+			t.Errorf("%d final witness rounds: checkFinalSumcheckRounds returned %v", witness-witness/2, err)
+		}
+	}
+
+	hidingOnly := gnarkNimue.IOPattern{Ops: spartanIO(0, 0).Ops[:4]}
+	if err := checkFinalSumcheckRounds(config, hidingOnly); err == nil || !strings.Contains(err.Error(), "has 1 WHIR proofs, expected 2") {
+		t.Errorf("single WHIR proof: checkFinalSumcheckRounds returned %v", err)
+	}
+}
+
+// scheduleIO returns the ops of events, where c is a Merkle commitment, o the STIR
+// answers of an opening and f the final coefficients ending a WHIR proof.
+func scheduleIO(events string) gnarkNimue.IOPattern {
+	labels := map[rune]gnarkNimue.Op{
+		'c': {Kind: gnarkNimue.Absorb, Label: []byte(merkleDigestLabel), Size: 1},
+		'o': {Kind: gnarkNimue.Hint, Label: []byte("stir_answers")},
+		'f': {Kind: gnarkNimue.Absorb, Label: []byte(finalCoefficientsLabel), Size: 1},
+	}
+	var io gnarkNimue.IOPattern
+	for _, event := range events {
+		io.Ops = append(io.Ops, labels[event])
+	}
+	return io
+}
+
+func TestRoundSchedules(t *testing.T) {
+	for _, tc := range []struct {
+		events   string
+		rounds   []int
+		expected [][]bool
+	}{
+		// The initial commitment precedes the rounds and is not part of them.
+		{"c" + "co" + "oc" + "f" + "c" + "oc" + "f", []int{2, 1}, [][]bool{{false, true}, {true}}},
+		{"c" + "f" + "c" + "co" + "f", []int{0, 1}, [][]bool{{}, {false}}},
+	} {
+		schedules, err := roundSchedules(scheduleIO(tc.events), tc.rounds)
+		if err != nil || !reflect.DeepEqual(schedules, tc.expected) {
+			t.Errorf("%s: roundSchedules = %v, %v, expected %v", tc.events, schedules, err, tc.expected)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		events  string
+		message string
+	}{
+		{"two commitments in a round", "cccf" + "cocf", "does not commit once and open once"},
+		{"too few commitments", "cf" + "cocf", "has 1 commitments and openings, expected at least 2"},
+		{"missing proof", "cocf", "has 1 WHIR proofs, expected 2"},
+		{"extra proof", "cocf" + "cocf" + "cocf", "has more than 2 WHIR proofs"},
+	} {
+		_, err := roundSchedules(scheduleIO(tc.events), []int{1, 1})
+		if err == nil || !strings.Contains(err.Error(), tc.message) {
+			t.Errorf("%s: roundSchedules returned %v, expected %q", tc.name, err, tc.message)
+		}
+	}
+}
+
+func TestCheckFinalPolynomialDegrees(t *testing.T) {
+	finalCoefficients := func(sizes ...uint64) gnarkNimue.IOPattern {
+		var io gnarkNimue.IOPattern
+		for _, size := range sizes {
+			io.Ops = append(io.Ops, gnarkNimue.Op{Kind: gnarkNimue.Absorb, Label: []byte(merkleDigestLabel), Size: 1})
+			io.Ops = append(io.Ops, gnarkNimue.Op{Kind: gnarkNimue.Absorb, Label: []byte(finalCoefficientsLabel), Size: size})
+		}
+		return io
+	}
+	// The hiding proof has no final sumcheck rounds and so a constant final
+	// polynomial; the witness proof has two, bounding it to 4 coefficients.
+	config := Config{WHIRConfigHidingSpartan: WHIRConfig{NVars: 8, FoldingFactor: []int{4}}, WHIRConfigWitness: WHIRConfig{NVars: 6, FoldingFactor: []int{4}}}
+	for _, sizes := range [][]uint64{{1, 4}, {1, 1}} {
+		if err := checkFinalPolynomialDegrees(config, finalCoefficients(sizes...)); err != nil {
+			t.Errorf("final polynomials of %v coefficients rejected: %v", sizes, err)
+		}
+	}
+	for _, sizes := range [][]uint64{{2, 4}, {1, 5}, {1, 8}} {
+		if err := checkFinalPolynomialDegrees(config, finalCoefficients(sizes...)); !errors.Is(err, ErrFinalPolynomialDegree) {
+			t.Errorf("final polynomials of %v coefficients: checkFinalPolynomialDegrees returned %v", sizes, err)
+		}
+	}
+	if err := checkFinalPolynomialDegrees(config, finalCoefficients(1, 4, 1)); err == nil || !strings.Contains(err.Error(), "more than 2 WHIR proofs") {
+		t.Errorf("third final polynomial: checkFinalPolynomialDegrees returned %v", err)
+	}
+}
+
+func TestPreflightBoundsTheFinalPolynomial(t *testing.T) {
+	config, r1cs := preflightInputs()
+	config.IOPattern = strings.Replace(config.IOPattern, "A1"+finalCoefficientsLabel, "A2"+finalCoefficientsLabel, 1)
+	if err := PreflightCheck(config, r1cs); !errors.Is(err, ErrFinalPolynomialDegree) {
+		t.Fatalf("linear final polynomial without final sumcheck rounds: PreflightCheck returned %v", err)
+	}
+}
@@ -23,18 +23,47 @@ type MultiPath[Digest any] struct {
 
 // WHIR specific types
 type WHIRConfig struct {
-	NRounds             int    `json:"n_rounds"`
-	Rate                int    `json:"rate"`
-	NVars               int    `json:"n_vars"`
-	FoldingFactor       []int  `json:"folding_factor"`
-	OODSamples          []int  `json:"ood_samples"`
-	NumQueries          []int  `json:"num_queries"`
-	PowBits             []int  `json:"pow_bits"`
-	FinalQueries        int    `json:"final_queries"`
-	FinalPowBits        int    `json:"final_pow_bits"`
-	FinalFoldingPowBits int    `json:"final_folding_pow_bits"`
-	DomainGenerator     string `json:"domain_generator"`
-	BatchSize           int    `json:"batch_size"`
+	NRounds               int    `json:"n_rounds"`
+	Rate                  int    `json:"rate"`
+	NVars                 int    `json:"n_vars"`
+	FoldingFactor         []int  `json:"folding_factor"`
+	OODSamples            []int  `json:"ood_samples"`
+	NumQueries            []int  `json:"num_queries"`
+	PowBits               []int  `json:"pow_bits"`
+	FinalQueries          int    `json:"final_queries"`
+	FinalPowBits          int    `json:"final_pow_bits"`
+	FinalFoldingPowBits   int    `json:"final_folding_pow_bits"`
+	DomainGenerator       string `json:"domain_generator"`
+	BatchSize             int    `json:"batch_size"`
+	SumcheckDegree        int    `json:"sumcheck_degree"`
+	ExtensionDegree       int    `json:"extension_degree"`
+	OODHashToField        string `json:"ood_hash_to_field"`
+	FoldingVariableOrder  string `json:"folding_variable_order"`
+	MerkleCapHeight       int    `json:"merkle_cap_height"`
+	BatchEvaluationPoints string `json:"batch_evaluation_points"`
+	// FinalWHIRConfig is set by WHIR variants that commit to the final polynomial
+	// under a nested WHIR instance instead of sending its coefficients. Such proofs
+	// are not supported and are rejected by Validate.
+	FinalWHIRConfig *WHIRConfig `json:"final_whir_config"`
+	// MultilinearStatement is set by protocols that require the statement weights to
+	// be multilinear, see WHIRParams.sumcheckDegreeBound.
+	MultilinearStatement bool `json:"multilinear_statement"`
+	// NumShards is the number of trees in a sharded Merkle forest, see
+	// merkleCapSize.
+	NumShards int `json:"num_shards"`
+	// CombinationRandomness selects how round constraints are combined, see
+	// GenerateCombinationRandomness.
+	CombinationRandomness string `json:"combination_randomness"`
+	// LeafLayout is the order of the batched polynomials within each leaf, see
+	// separateBatchedLeaves.
+	LeafLayout string `json:"leaf_layout"`
+	// ChallengeReduction selects how the batching, folding and combination
+	// challenges are derived from the transcript, see ChallengeReducer.
+	ChallengeReduction string `json:"challenge_reduction"`
+
+	// roundOpensFirst is the round schedule read from the IO pattern, see
+	// roundSchedules.
+	roundOpensFirst []bool
 }
 
 type WHIRParams struct {
@@ -52,6 +81,19 @@ type WHIRParams struct {
 	FinalSumcheckRounds                  int
 	MVParamsNumberOfVariables            int
 	BatchSize                            int
+	SumcheckDegree                       int
+	OODHashToField                       string
+	FoldingVariableOrder                 string
+	MerkleCapSize                        int
+	ReportMismatches                     bool
+	RoundOpensFirst                      []bool
+	MultilinearStatement                 bool
+	CombinationRandomness                string
+	LeafLayout                           string
+	ChallengeReduction                   string
+	// NumStatements is the number of statements each polynomial of the batch is
+	// evaluated against, fixed by the protocol rather than read off the proof.
+	NumStatements int
 }
 
 type MainRoundData struct {
@@ -96,6 +138,13 @@ type Config struct {
 	TranscriptLen                int        `json:"transcript_len"`
 	WitnessStatementEvaluations  []string   `json:"witness_statement_evaluations"`
 	BlindingStatementEvaluations []string   `json:"blinding_statement_evaluations"`
+	SpartanSumcheckDegree        int        `json:"spartan_sumcheck_degree"`
+	MatrixLayout                 string     `json:"matrix_layout"`
+	TranscriptSponge             string     `json:"transcript_sponge"`
+	SumArgument                  bool       `json:"sum_argument"`
+	FieldEncoding                string     `json:"field_encoding"`
+	TranscriptFinalState         string     `json:"transcript_final_state"`
+	ConstraintSystem             string     `json:"constraint_system"`
 }
 
 type Hints struct {
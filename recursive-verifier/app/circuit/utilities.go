@@ -2,6 +2,7 @@ package circuit
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -19,17 +20,39 @@ import (
 	skyscraper "github.com/reilabs/gnark-skyscraper"
 )
 
-func calculateEQ(api frontend.API, alphas []frontend.Variable, r []frontend.Variable) frontend.Variable {
-	ans := frontend.Variable(1)
-	for i, alpha := range alphas {
-		ans = api.Mul(ans, api.Add(api.Mul(alpha, r[i]), api.Mul(api.Sub(frontend.Variable(1), alpha), api.Sub(frontend.Variable(1), r[i]))))
+// OuterSumcheckClaim returns the value the last round of Spartan's outer sumcheck
+// must reduce to: the constraint error of cs at the sumcheck point r, computed from
+// the claimed evaluations of Az, Bz and Cz there, weighted by eq(tau, r) for the
+// transcript challenge tau. For R1CS the error is Az(r)*Bz(r) - Cz(r). The
+// weighting makes the sum over the hypercube a random combination of the
+// per-constraint errors, so it vanishes only if every constraint is satisfied (up
+// to the soundness error).
+func OuterSumcheckClaim(api frontend.API, cs ConstraintSystem, evaluations []frontend.Variable, tau []frontend.Variable, point []frontend.Variable) frontend.Variable {
+	constraintError := cs.EvaluateConstraints(api, evaluations, point)
+	return api.Mul(constraintError, utilities.EqPolyOutside(api, point, tau))
+}
+
+// AssertOuterSumcheckClaim asserts that the Spartan outer sumcheck reduces to its
+// OuterSumcheckClaim, lastValue. tau must have one challenge per variable of the
+// sumcheck point: the eq weight is what makes the claim a random combination of the
+// per-constraint errors, so a weight that leaves a variable out is rejected.
+func AssertOuterSumcheckClaim(api frontend.API, cs ConstraintSystem, evaluations []frontend.Variable, tau []frontend.Variable, point []frontend.Variable, lastValue frontend.Variable) error {
+	if len(tau) != len(point) {
+		return fmt.Errorf("outer sumcheck has %d eq challenges for a point of %d variables", len(tau), len(point))
 	}
-	return ans
+	api.AssertIsEqual(lastValue, OuterSumcheckClaim(api, cs, evaluations, tau, point))
+	return nil
 }
 
 func initializeComponents(api frontend.API, circuit *Circuit) (*skyscraper.Skyscraper, gnarkNimue.Arthur, *uints.BinaryField[uints.U64], error) {
 	sc := skyscraper.NewSkyscraper(api, 2)
-	arthur, err := gnarkNimue.NewSkyscraperArthur(api, sc, circuit.IO, circuit.Transcript[:], true)
+	var arthur gnarkNimue.Arthur
+	var err error
+	if circuit.TranscriptSponge == poseidon2Transcript {
+		arthur, err = NewPoseidonTranscript(api, circuit.IO, circuit.Transcript[:])
+	} else {
+		arthur, err = gnarkNimue.NewSkyscraperArthur(api, sc, circuit.IO, circuit.Transcript[:], true)
+	}
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -111,7 +134,18 @@ func keysFromUrl(pkUrl string, vkUrl string) (groth16.ProvingKey, groth16.Verify
 }
 
 func downloadFromUrl(url string) ([]byte, error) {
-	resp, err := http.Get(url)
+	return downloadFromUrlWithContext(context.Background(), http.DefaultClient, url, -1)
+}
+
+// downloadFromUrlWithContext downloads url with client, aborting when ctx is done or
+// the client times out. If limit is non-negative, responses larger than limit bytes
+// are rejected.
+func downloadFromUrlWithContext(ctx context.Context, client *http.Client, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download from %s: %w", url, err)
 	}
@@ -125,12 +159,23 @@ func downloadFromUrl(url string) ([]byte, error) {
 		return nil, fmt.Errorf("HTTP error %d when downloading from %s", resp.StatusCode, url)
 	}
 
+	var body io.Reader = resp.Body
+	if limit >= 0 {
+		if resp.ContentLength > limit {
+			return nil, fmt.Errorf("response from %s is %d bytes, exceeding the limit of %d bytes", url, resp.ContentLength, limit)
+		}
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+
 	buffer := &bytes.Buffer{}
 
-	_, err = io.Copy(buffer, resp.Body)
+	_, err = io.Copy(buffer, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to copy to buffer: %w", err)
 	}
+	if limit >= 0 && int64(buffer.Len()) > limit {
+		return nil, fmt.Errorf("response from %s exceeds the limit of %d bytes", url, limit)
+	}
 
 	return buffer.Bytes(), nil
 }
@@ -142,7 +187,8 @@ func runSumcheck(
 	foldingFactor int,
 	polynomialDegree int,
 ) ([]frontend.Variable, frontend.Variable, error) {
-	sumcheckPolynomial := make([]frontend.Variable, polynomialDegree)
+	// Round polynomials are sent in coefficient form, lowest degree first.
+	sumcheckPolynomial := make([]frontend.Variable, polynomialDegree+1)
 	foldingRandomness := make([]frontend.Variable, foldingFactor)
 	foldingRandomnessTemp := make([]frontend.Variable, 1)
 
@@ -154,12 +200,7 @@ func runSumcheck(
 			return nil, nil, err
 		}
 		foldingRandomness[i] = foldingRandomnessTemp[0]
-		sumcheckVal := api.Add(
-			utilities.UnivarPoly(api, sumcheckPolynomial, []frontend.Variable{0})[0],
-			utilities.UnivarPoly(api, sumcheckPolynomial, []frontend.Variable{1})[0],
-		)
-		api.AssertIsEqual(sumcheckVal, lastEval)
-		lastEval = utilities.UnivarPoly(api, sumcheckPolynomial, []frontend.Variable{foldingRandomness[i]})[0]
+		lastEval = utilities.VerifySumcheckRound(api, sumcheckPolynomial, lastEval, foldingRandomness[i])
 	}
 	return foldingRandomness, lastEval, nil
 }
@@ -176,7 +217,7 @@ func runZKSumcheck(
 	whirParams WHIRParams,
 ) ([]frontend.Variable, frontend.Variable, error) {
 
-	rootHash, batchingRandomness, initialOODQueries, initialOODAnswers, err := parseBatchedCommitment(arthur, whirParams)
+	rootHash, batchingRandomness, initialOODQueries, initialOODAnswers, err := parseBatchedCommitment(api, arthur, whirParams)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,67 @@
+package circuit
+
+import (
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+type outerSumcheckClaimCircuit struct {
+	Evaluations []frontend.Variable
+	Tau         []frontend.Variable
+	Point       []frontend.Variable
+	LastValue   frontend.Variable
+}
+
+func (c *outerSumcheckClaimCircuit) Define(api frontend.API) error {
+	return AssertOuterSumcheckClaim(api, r1csConstraints{}, c.Evaluations, c.Tau, c.Point, c.LastValue)
+}
+
+func TestAssertOuterSumcheckClaim(t *testing.T) {
+	shape := &outerSumcheckClaimCircuit{
+		Evaluations: make([]frontend.Variable, 3),
+		Tau:         make([]frontend.Variable, 2),
+		Point:       make([]frontend.Variable, 2),
+	}
+	// (3*4 - 5) * eq((2, 3), (5, 7)) = 7 * (2*5 + (-1)*(-4)) * (3*7 + (-2)*(-6)) = 7 * 14 * 33.
+	honest := &outerSumcheckClaimCircuit{
+		Evaluations: []frontend.Variable{3, 4, 5},
+		Tau:         []frontend.Variable{2, 3},
+		Point:       []frontend.Variable{5, 7},
+		LastValue:   3234,
+	}
+	if err := test.IsSolved(shape, honest, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("eq-weighted claim rejected: %v", err)
+	}
+
+	wrongTau := *honest
+	wrongTau.Tau = []frontend.Variable{2, 4}
+	if err := test.IsSolved(shape, &wrongTau, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("claim accepted under the wrong tau")
+	}
+
+	// Without the eq weight the claim is the bare constraint error.
+	unweighted := *honest
+	unweighted.LastValue = 7
+	if err := test.IsSolved(shape, &unweighted, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("unweighted claim accepted")
+	}
+
+	// 98 is the claim with only the first variable weighted.
+	missingTau := &outerSumcheckClaimCircuit{
+		Evaluations: make([]frontend.Variable, 3),
+		Tau:         make([]frontend.Variable, 1),
+		Point:       make([]frontend.Variable, 2),
+	}
+	assignment := &outerSumcheckClaimCircuit{
+		Evaluations: []frontend.Variable{3, 4, 5},
+		Tau:         []frontend.Variable{2},
+		Point:       []frontend.Variable{5, 7},
+		LastValue:   98,
+	}
+	if err := test.IsSolved(missingTau, assignment, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("claim accepted with a tau challenge missing")
+	}
+}
@@ -0,0 +1,362 @@
+package circuit
+
+import (
+	"fmt"
+	"log"
+	"math/bits"
+
+	"reilabs/whir-verifier-circuit/app/typeConverters"
+	"reilabs/whir-verifier-circuit/app/utilities"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// Validate checks that the configuration is internally consistent before it is used
+// to build the verifier circuit.
+func (cfg Config) Validate() error {
+	if err := cfg.WHIRConfigWitness.Validate(); err != nil {
+		return fmt.Errorf("whir_config_witness: %w", err)
+	}
+	if err := cfg.WHIRConfigHidingSpartan.Validate(); err != nil {
+		return fmt.Errorf("whir_config_hiding_spartan: %w", err)
+	}
+	// The matrix evaluation point is the folding randomness of the witness WHIR
+	// proof, which has one coordinate per variable of the witness polynomial.
+	if cfg.WHIRConfigWitness.NVars != cfg.LogNumVariables {
+		return fmt.Errorf("whir_config_witness has %d variables, expected log_num_variables = %d", cfg.WHIRConfigWitness.NVars, cfg.LogNumVariables)
+	}
+	if cfg.LogNumConstraints < 0 {
+		return fmt.Errorf("log_num_constraints must not be negative, got %d", cfg.LogNumConstraints)
+	}
+	if cfg.SpartanSumcheckDegree < 0 {
+		return fmt.Errorf("spartan_sumcheck_degree must not be negative, got %d", cfg.SpartanSumcheckDegree)
+	}
+	switch cfg.MatrixLayout {
+	case "", rowMajorLayout, columnMajorLayout:
+	default:
+		return fmt.Errorf("unknown matrix_layout %q, expected %q or %q", cfg.MatrixLayout, rowMajorLayout, columnMajorLayout)
+	}
+	switch cfg.FieldEncoding {
+	case "", fixedFieldEncoding, lengthPrefixedFieldEncoding:
+	default:
+		return fmt.Errorf("unknown field_encoding %q, expected %q or %q", cfg.FieldEncoding, fixedFieldEncoding, lengthPrefixedFieldEncoding)
+	}
+	if cfg.TranscriptFinalState != "" {
+		if _, err := cfg.transcriptFinalState(); err != nil {
+			return err
+		}
+	}
+	switch cfg.ConstraintSystem {
+	case "", r1csConstraintSystem:
+	case plonkishConstraintSystem:
+		if cfg.spartanSumcheckDegree() < plonkishSpartanSumcheckDegree {
+			return fmt.Errorf("constraint_system %q needs spartan_sumcheck_degree of at least %d, got %d", cfg.ConstraintSystem, plonkishSpartanSumcheckDegree, cfg.spartanSumcheckDegree())
+		}
+	default:
+		return fmt.Errorf("unknown constraint_system %q, expected %q or %q", cfg.ConstraintSystem, r1csConstraintSystem, plonkishConstraintSystem)
+	}
+	switch cfg.TranscriptSponge {
+	case "", skyscraperTranscript, poseidon2Transcript:
+	default:
+		return fmt.Errorf("unknown transcript_sponge %q, expected %q or %q", cfg.TranscriptSponge, skyscraperTranscript, poseidon2Transcript)
+	}
+	return nil
+}
+
+// Validate checks that the per-round parameters cover every round and that all
+// proof-of-work difficulties can be checked by the circuit.
+//
+// Parameter sets generated by WHIR use a monotone pow_bits schedule (non-increasing
+// or non-decreasing across rounds). A schedule that changes direction is accepted,
+// as the circuit enforces whatever difficulty each round declares, but it is logged
+// because it usually means the parameters were mis-specified or assembled by hand.
+func (cfg WHIRConfig) Validate() error {
+	if cfg.NRounds < 0 {
+		return fmt.Errorf("n_rounds must not be negative, got %d", cfg.NRounds)
+	}
+	if cfg.NVars <= 0 {
+		return fmt.Errorf("n_vars must be positive, got %d", cfg.NVars)
+	}
+	// Both commitments batch a polynomial with its blinding polynomial, and the
+	// circuit is handed exactly those two sets of statement evaluations.
+	if cfg.BatchSize != zkBatchSize {
+		return fmt.Errorf("batch_size must be %d, got %d", zkBatchSize, cfg.BatchSize)
+	}
+	if cfg.SumcheckDegree < 0 {
+		return fmt.Errorf("sumcheck_degree must not be negative, got %d", cfg.SumcheckDegree)
+	}
+	if cfg.ExtensionDegree < 0 {
+		return fmt.Errorf("extension_degree must not be negative, got %d", cfg.ExtensionDegree)
+	}
+	if cfg.ExtensionDegree > 1 {
+		return fmt.Errorf("extension_degree %d is not supported, only WHIR instances over the base field can be verified", cfg.ExtensionDegree)
+	}
+	// The circuit reads the final polynomial from the transcript as final_coeffs;
+	// there is no verifier for a nested commitment to it.
+	if cfg.FinalWHIRConfig != nil {
+		return fmt.Errorf("final_whir_config is not supported, the final polynomial must be sent in the clear")
+	}
+	switch cfg.OODHashToField {
+	case "", nativeHashToField, bytesHashToField:
+	default:
+		return fmt.Errorf("unknown ood_hash_to_field %q, expected %q or %q", cfg.OODHashToField, nativeHashToField, bytesHashToField)
+	}
+	if cfg.MerkleCapHeight < 0 || cfg.MerkleCapHeight >= 32 {
+		return fmt.Errorf("merkle_cap_height %d outside of supported range [0, 31]", cfg.MerkleCapHeight)
+	}
+	if cfg.NumShards < 0 || cfg.NumShards&(cfg.NumShards-1) != 0 || cfg.NumShards > 1<<31 {
+		return fmt.Errorf("num_shards %d must be zero or a power of two of at most 2^31", cfg.NumShards)
+	}
+	if cfg.NumShards > 0 && cfg.MerkleCapHeight != 0 && cfg.NumShards != 1<<cfg.MerkleCapHeight {
+		return fmt.Errorf("num_shards %d does not match merkle_cap_height %d", cfg.NumShards, cfg.MerkleCapHeight)
+	}
+	switch cfg.BatchEvaluationPoints {
+	case "", sharedBatchPoints:
+	default:
+		return fmt.Errorf("unknown batch_evaluation_points %q, expected %q", cfg.BatchEvaluationPoints, sharedBatchPoints)
+	}
+	switch cfg.CombinationRandomness {
+	case "", powersCombinationRandomness, independentCombinationRandomness:
+	default:
+		return fmt.Errorf("unknown combination_randomness %q, expected %q or %q", cfg.CombinationRandomness, powersCombinationRandomness, independentCombinationRandomness)
+	}
+	switch cfg.ChallengeReduction {
+	case "", nativeChallengeReduction, modularChallengeReduction, rejectionChallengeReduction:
+	default:
+		return fmt.Errorf("unknown challenge_reduction %q, expected %q, %q or %q", cfg.ChallengeReduction, nativeChallengeReduction, modularChallengeReduction, rejectionChallengeReduction)
+	}
+	switch cfg.LeafLayout {
+	case "", concatenatedLeafLayout, interleavedLeafLayout:
+	default:
+		return fmt.Errorf("unknown leaf_layout %q, expected %q or %q", cfg.LeafLayout, concatenatedLeafLayout, interleavedLeafLayout)
+	}
+	switch cfg.FoldingVariableOrder {
+	case "", reversedFoldingOrder, inOrderFoldingOrder:
+	default:
+		return fmt.Errorf("unknown folding_variable_order %q, expected %q or %q", cfg.FoldingVariableOrder, reversedFoldingOrder, inOrderFoldingOrder)
+	}
+	for i, factor := range cfg.FoldingFactor {
+		if factor <= 0 {
+			return fmt.Errorf("folding_factor[%d] must be positive, got %d", i, factor)
+		}
+	}
+
+	if len(cfg.OODSamples) != cfg.NRounds {
+		return fmt.Errorf("ood_samples has %d entries, expected one per round (%d)", len(cfg.OODSamples), cfg.NRounds)
+	}
+	if len(cfg.NumQueries) != cfg.NRounds {
+		return fmt.Errorf("num_queries has %d entries, expected one per round (%d)", len(cfg.NumQueries), cfg.NRounds)
+	}
+	if len(cfg.PowBits) != cfg.NRounds {
+		return fmt.Errorf("pow_bits has %d entries, expected one per round (%d)", len(cfg.PowBits), cfg.NRounds)
+	}
+
+	for i, bits := range cfg.PowBits {
+		if err := checkPoWDifficulty(bits); err != nil {
+			return fmt.Errorf("pow_bits[%d]: %w", i, err)
+		}
+	}
+	if err := checkPoWDifficulty(cfg.FinalPowBits); err != nil {
+		return fmt.Errorf("final_pow_bits: %w", err)
+	}
+	if err := checkPoWDifficulty(cfg.FinalFoldingPowBits); err != nil {
+		return fmt.Errorf("final_folding_pow_bits: %w", err)
+	}
+
+	if round := powScheduleTurningPoint(cfg.PowBits); round >= 0 {
+		log.Printf("Warning: pow_bits %v change direction at round %d, expected a monotone schedule", cfg.PowBits, round)
+	}
+
+	return nil
+}
+
+func checkPoWDifficulty(bits int) error {
+	if bits < 0 || bits > utilities.MaxPoWDifficulty {
+		return fmt.Errorf("difficulty %d outside of supported range [0, %d]", bits, utilities.MaxPoWDifficulty)
+	}
+	return nil
+}
+
+// powScheduleTurningPoint returns the first round at which powBits stops being
+// monotone, or -1 if the schedule is monotone.
+func powScheduleTurningPoint(powBits []int) int {
+	direction := 0
+	for i := 1; i < len(powBits); i++ {
+		step := powBits[i] - powBits[i-1]
+		if step == 0 {
+			continue
+		}
+		if step > 0 {
+			step = 1
+		} else {
+			step = -1
+		}
+		if direction != 0 && step != direction {
+			return i
+		}
+		direction = step
+	}
+	return -1
+}
+
+// ValidateStructure checks that the hint is self-consistent without needing the
+// config: every Merkle multi-path has one sibling hash, auth path and set of STIR
+// answers per opened leaf, the auth paths decode to a common height and the answers
+// of each opening are rectangular.
+func (h *ZKHint) ValidateStructure() error {
+	first := h.firstRoundMerklePaths
+	if err := first.path.validateStructure(); err != nil {
+		return fmt.Errorf("first round: %w", err)
+	}
+	if len(first.path.stirAnswers) > 0 && len(first.expectedStirAnswers) != len(first.path.stirAnswers[0]) {
+		return fmt.Errorf("first round: %d expected STIR answers for %d opened leaves", len(first.expectedStirAnswers), len(first.path.stirAnswers[0]))
+	}
+	if err := h.roundHints.validateStructure(); err != nil {
+		return fmt.Errorf("rounds: %w", err)
+	}
+	return nil
+}
+
+// checkQueryCounts checks that every round of h opens as many leaves as cfg squeezes
+// query indices for it: NumQueries[r] for round r and FinalQueries for the final
+// queries. Indices that collide are opened once, so a round may open fewer leaves
+// than it has queries, but never none or more. The exact count, the number of
+// distinct indices the transcript squeezes, needs the sponge and is enforced in the
+// circuit by utilities.AssertOpensQueries.
+func (h *ZKHint) checkQueryCounts(cfg WHIRConfig) error {
+	paths := append(append([]MultiPath[KeccakDigest]{}, h.firstRoundMerklePaths.path.merklePaths...), h.roundHints.merklePaths...)
+	if len(paths) != cfg.NRounds+1 {
+		return fmt.Errorf("%w: %d rounds of openings, expected %d", ErrQueryCountMismatch, len(paths), cfg.NRounds+1)
+	}
+	for round, path := range paths {
+		queries := cfg.FinalQueries
+		if round < cfg.NRounds {
+			queries = cfg.NumQueries[round]
+		}
+		if opened := len(path.LeafIndexes); opened == 0 || opened > queries {
+			return fmt.Errorf("%w: round %d opens %d leaves for %d queries", ErrQueryCountMismatch, round, opened, queries)
+		}
+	}
+	return nil
+}
+
+func (h Hint) validateStructure() error {
+	if len(h.merklePaths) != len(h.stirAnswers) {
+		return fmt.Errorf("%d merkle paths but %d sets of STIR answers", len(h.merklePaths), len(h.stirAnswers))
+	}
+	for i, path := range h.merklePaths {
+		if err := path.validateStructure(); err != nil {
+			return fmt.Errorf("merkle path %d: %w", i, err)
+		}
+		answers := h.stirAnswers[i]
+		if len(answers) != len(path.LeafIndexes) {
+			return fmt.Errorf("merkle path %d opens %d leaves but has %d STIR answers", i, len(path.LeafIndexes), len(answers))
+		}
+		for j := range answers {
+			if len(answers[j]) < 2 {
+				return fmt.Errorf("STIR answers %d: leaf %d has %d values, expected at least 2", i, j, len(answers[j]))
+			}
+			if len(answers[j]) != len(answers[0]) {
+				return fmt.Errorf("STIR answers %d: leaf %d has %d values, leaf 0 has %d", i, j, len(answers[j]), len(answers[0]))
+			}
+		}
+	}
+	return nil
+}
+
+func (path MultiPath[Digest]) validateStructure() error {
+	leaves := len(path.LeafIndexes)
+	if leaves == 0 {
+		return fmt.Errorf("no leaves are opened")
+	}
+	if len(path.LeafSiblingHashes) != leaves || len(path.AuthPathsPrefixLengths) != leaves || len(path.AuthPathsSuffixes) != leaves {
+		return fmt.Errorf("%d leaf indexes, %d sibling hashes, %d prefix lengths and %d auth path suffixes, expected one of each per leaf",
+			leaves, len(path.LeafSiblingHashes), len(path.AuthPathsPrefixLengths), len(path.AuthPathsSuffixes))
+	}
+	height := uint64(len(path.AuthPathsSuffixes[0]))
+	for j := 1; j < leaves; j++ {
+		prefix := path.AuthPathsPrefixLengths[j]
+		if prefix > height || prefix+uint64(len(path.AuthPathsSuffixes[j])) != height {
+			return fmt.Errorf("auth path %d decodes to height %d, auth path 0 has height %d", j, prefix+uint64(len(path.AuthPathsSuffixes[j])), height)
+		}
+	}
+	return nil
+}
+
+func checkCanonical(value Fp256) error {
+	if typeConverters.LimbsToBigInt(value.Limbs).Cmp(ecc.BN254.ScalarField()) >= 0 {
+		return fmt.Errorf("value is not reduced modulo the field")
+	}
+	return nil
+}
+
+// validateShape checks that the sparse matrices of the R1CS are well formed and fit
+// in the 2^log_num_constraints by 2^log_num_variables matrices the circuit
+// evaluates, so that expanding them cannot index out of range. It also checks that
+// log_a_num_terms is the base-2 logarithm of the number of terms of A rounded up,
+// as ProveKit computes it when sizing the padded term list of A, and that
+// log_num_variables is one more than the base-2 logarithm of the number of
+// witnesses rounded up, the extra variable being the one ProveKit adds for the
+// masking polynomial. Plonkish selectors must likewise index rows the circuit
+// evaluates.
+func (r1cs R1CS) validateShape(cfg Config) error {
+	if want := ceilLog2(int(r1cs.Witnesses)) + 1; cfg.LogNumVariables != want {
+		return fmt.Errorf("log_num_variables is %d but the r1cs has %d witnesses, expected %d", cfg.LogNumVariables, r1cs.Witnesses, want)
+	}
+	if terms := len(r1cs.A.Values); cfg.LogANumTerms != ceilLog2(terms) {
+		return fmt.Errorf("log_a_num_terms is %d but matrix a has %d terms, expected %d", cfg.LogANumTerms, terms, ceilLog2(terms))
+	}
+	for _, m := range []struct {
+		name   string
+		matrix SparseMatrix
+	}{{"a", r1cs.A}, {"b", r1cs.B}, {"c", r1cs.C}} {
+		if err := m.matrix.validateShape(cfg); err != nil {
+			return fmt.Errorf("r1cs matrix %s: %w", m.name, err)
+		}
+	}
+	if cfg.ConstraintSystem == plonkishConstraintSystem {
+		if r1cs.Selectors == nil {
+			return fmt.Errorf("constraint_system %q needs selectors", plonkishConstraintSystem)
+		}
+		if err := r1cs.Selectors.validateShape(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m SparseMatrix) validateShape(cfg Config) error {
+	maxRows, maxCols := uint64(1)<<cfg.LogNumConstraints, uint64(1)<<cfg.LogNumVariables
+	if m.Rows > maxRows || m.Cols > maxCols {
+		return fmt.Errorf("matrix is %dx%d, expected at most %dx%d", m.Rows, m.Cols, maxRows, maxCols)
+	}
+	if len(m.ColIndices) != len(m.Values) {
+		return fmt.Errorf("%d col_indices for %d values", len(m.ColIndices), len(m.Values))
+	}
+	outer, inner := m.Rows, m.Cols
+	if cfg.MatrixLayout == columnMajorLayout {
+		outer, inner = inner, outer
+	}
+	if uint64(len(m.RowIndices)) > outer {
+		return fmt.Errorf("%d new_row_indices for %d outer indices", len(m.RowIndices), outer)
+	}
+	for i, offset := range m.RowIndices {
+		if offset > uint64(len(m.Values)) || (i > 0 && offset < m.RowIndices[i-1]) {
+			return fmt.Errorf("new_row_indices[%d] = %d is not a non-decreasing offset into %d values", i, offset, len(m.Values))
+		}
+	}
+	for j, index := range m.ColIndices {
+		if index >= inner {
+			return fmt.Errorf("col_indices[%d] = %d is out of range for %d inner indices", j, index, inner)
+		}
+	}
+	return nil
+}
+
+// ceilLog2 returns the smallest k with 2^k >= n, and 0 for n <= 1.
+func ceilLog2(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(uint(n - 1))
+}
@@ -0,0 +1,245 @@
+package circuit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// validWHIRConfig returns a single-round WHIR config that Validate accepts.
+func validWHIRConfig() WHIRConfig {
+	return WHIRConfig{
+		NRounds:         1,
+		NVars:           8,
+		FoldingFactor:   []int{4},
+		OODSamples:      []int{1},
+		NumQueries:      []int{2},
+		PowBits:         []int{0},
+		FinalQueries:    2,
+		DomainGenerator: "1",
+		BatchSize:       2,
+	}
+}
+
+func TestWHIRConfigValidateBatchSize(t *testing.T) {
+	// The circuit only ever batches a polynomial with its blinding polynomial.
+	for _, size := range []int{0, 1, 3} {
+		cfg := validWHIRConfig()
+		cfg.BatchSize = size
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("batch_size %d accepted", size)
+		}
+	}
+}
+
+func TestWHIRConfigValidateChallengeReduction(t *testing.T) {
+	for _, reduction := range []string{"", nativeChallengeReduction, modularChallengeReduction, rejectionChallengeReduction} {
+		cfg := validWHIRConfig()
+		cfg.ChallengeReduction = reduction
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("challenge_reduction %q rejected: %v", reduction, err)
+		}
+	}
+	cfg := validWHIRConfig()
+	cfg.ChallengeReduction = "truncated"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("unknown challenge_reduction accepted")
+	}
+}
+
+func TestPowScheduleTurningPoint(t *testing.T) {
+	for _, tc := range []struct {
+		powBits []int
+		round   int
+	}{
+		{nil, -1},
+		{[]int{5}, -1},
+		{[]int{3, 3, 5, 7}, -1},
+		{[]int{9, 7, 7, 2}, -1},
+		{[]int{3, 5, 4}, 2},
+		{[]int{6, 6, 4, 4, 5}, 4},
+	} {
+		if round := powScheduleTurningPoint(tc.powBits); round != tc.round {
+			t.Errorf("powScheduleTurningPoint(%v) = %d, expected %d", tc.powBits, round, tc.round)
+		}
+	}
+}
+
+func TestWHIRConfigValidatePoWDifficulties(t *testing.T) {
+	for name, tamper := range map[string]func(*WHIRConfig){
+		"negative pow_bits":            func(cfg *WHIRConfig) { cfg.PowBits[0] = -1 },
+		"pow_bits above the maximum":   func(cfg *WHIRConfig) { cfg.PowBits[0] = 28 },
+		"final_pow_bits above it":      func(cfg *WHIRConfig) { cfg.FinalPowBits = 28 },
+		"final_folding_pow_bits above": func(cfg *WHIRConfig) { cfg.FinalFoldingPowBits = 28 },
+		"pow_bits for two rounds":      func(cfg *WHIRConfig) { cfg.PowBits = []int{0, 0} },
+	} {
+		cfg := validWHIRConfig()
+		tamper(&cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+	cfg := validWHIRConfig()
+	cfg.PowBits[0], cfg.FinalPowBits = 27, 27
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("maximum difficulty rejected: %v", err)
+	}
+}
+
+func TestWHIRConfigValidateBatchEvaluationPoints(t *testing.T) {
+	for _, points := range []string{"", sharedBatchPoints} {
+		cfg := validWHIRConfig()
+		cfg.BatchEvaluationPoints = points
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("batch_evaluation_points %q rejected: %v", points, err)
+		}
+	}
+	// Per-polynomial points are not verified, so they must not be accepted as if
+	// the points were shared.
+	cfg := validWHIRConfig()
+	cfg.BatchEvaluationPoints = "per_polynomial"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("per-polynomial points accepted")
+	}
+}
+
+func TestWHIRConfigValidateRejectsNestedFinalCommitment(t *testing.T) {
+	nested := validWHIRConfig()
+	cfg := validWHIRConfig()
+	cfg.FinalWHIRConfig = &nested
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "final_whir_config is not supported") {
+		t.Fatalf("nested final commitment not rejected as unsupported: %v", err)
+	}
+}
+
+func TestR1CSValidateShapeChecksLogANumTerms(t *testing.T) {
+	a := SparseMatrix{Rows: 2, Cols: 2, RowIndices: []uint64{0, 2}, ColIndices: []uint64{0, 1, 1}, Values: []uint64{0, 1, 2}}
+	r1cs := R1CS{A: a}
+	cfg := Config{LogNumConstraints: 1, LogNumVariables: 1, LogANumTerms: 2}
+	if err := r1cs.validateShape(cfg); err != nil {
+		t.Fatalf("3 terms of a rejected for log_a_num_terms = 2: %v", err)
+	}
+	cfg.LogANumTerms = 1
+	if err := r1cs.validateShape(cfg); err == nil {
+		t.Fatal("3 terms of a accepted for log_a_num_terms = 1")
+	}
+}
+
+func TestR1CSValidateShapeChecksLogNumVariables(t *testing.T) {
+	cfg := Config{LogNumVariables: 4}
+	for witnesses, valid := range map[uint64]bool{5: true, 8: true, 4: false, 9: false} {
+		err := R1CS{Witnesses: witnesses}.validateShape(cfg)
+		if (err == nil) != valid {
+			t.Errorf("%d witnesses for log_num_variables = 4: validateShape returned %v", witnesses, err)
+		}
+	}
+}
+
+func TestZKHintCheckQueryCounts(t *testing.T) {
+	cfg := WHIRConfig{NRounds: 1, NumQueries: []int{4}, FinalQueries: 3}
+	hint := func(first int, final int) ZKHint {
+		return ZKHint{
+			firstRoundMerklePaths: FirstRoundHint{path: syntheticHint(1, first, 4, 2)},
+			roundHints:            syntheticHint(1, final, 4, 2),
+		}
+	}
+	for _, tc := range []struct {
+		name         string
+		first, final int
+		valid        bool
+	}{
+		{"one opening per query", 4, 3, true},
+		{"colliding queries opened once", 3, 2, true},
+		{"round opening one leaf too many", 5, 3, false},
+		{"final queries opening one leaf too many", 4, 4, false},
+	} {
+		h := hint(tc.first, tc.final)
+		err := h.checkQueryCounts(cfg)
+		if tc.valid && err != nil {
+			t.Errorf("%s: rejected: %v", tc.name, err)
+		}
+		if !tc.valid && !errors.Is(err, ErrQueryCountMismatch) {
+			t.Errorf("%s: checkQueryCounts returned %v", tc.name, err)
+		}
+	}
+
+	empty := hint(4, 3)
+	empty.roundHints.merklePaths[0].LeafIndexes = nil
+	if err := empty.checkQueryCounts(cfg); !errors.Is(err, ErrQueryCountMismatch) {
+		t.Errorf("final queries without openings: checkQueryCounts returned %v", err)
+	}
+	missingRound := hint(4, 3)
+	missingRound.roundHints.merklePaths = nil
+	if err := missingRound.checkQueryCounts(cfg); !errors.Is(err, ErrQueryCountMismatch) {
+		t.Errorf("missing round of openings: checkQueryCounts returned %v", err)
+	}
+}
+
+// validZKHint returns a hint that opens three leaves of height four with two
+// values each, in the first round and in two later rounds.
+func validZKHint() ZKHint {
+	return ZKHint{
+		firstRoundMerklePaths: FirstRoundHint{path: syntheticHint(1, 3, 4, 2), expectedStirAnswers: make([][]Fp256, 3)},
+		roundHints:            syntheticHint(2, 3, 4, 2),
+	}
+}
+
+func TestZKHintValidateStructure(t *testing.T) {
+	hint := validZKHint()
+	if err := hint.ValidateStructure(); err != nil {
+		t.Fatalf("well-formed hint rejected: %v", err)
+	}
+
+	for name, tamper := range map[string]func(h *ZKHint){
+		"missing sibling hash": func(h *ZKHint) {
+			path := &h.roundHints.merklePaths[1]
+			path.LeafSiblingHashes = path.LeafSiblingHashes[:2]
+		},
+		"auth path of another height": func(h *ZKHint) {
+			h.roundHints.merklePaths[0].AuthPathsPrefixLengths[2] = 1
+		},
+		"no opened leaves": func(h *ZKHint) {
+			h.firstRoundMerklePaths.path.merklePaths[0] = MultiPath[KeccakDigest]{}
+		},
+		"missing STIR answers": func(h *ZKHint) {
+			h.roundHints.stirAnswers = h.roundHints.stirAnswers[:1]
+		},
+		"ragged STIR answers": func(h *ZKHint) {
+			h.roundHints.stirAnswers[0][2] = make([]Fp256, 3)
+		},
+		"single STIR answer": func(h *ZKHint) {
+			for j := range h.roundHints.stirAnswers[1] {
+				h.roundHints.stirAnswers[1][j] = make([]Fp256, 1)
+			}
+		},
+		"expected STIR answers miscounted": func(h *ZKHint) {
+			h.firstRoundMerklePaths.expectedStirAnswers = make([][]Fp256, 2)
+		},
+	} {
+		hint := validZKHint()
+		tamper(&hint)
+		if err := hint.ValidateStructure(); err == nil {
+			t.Errorf("hint with %s accepted", name)
+		}
+	}
+}
+
+func TestWHIRConfigValidateNumShards(t *testing.T) {
+	for _, tc := range []struct {
+		shards, capHeight int
+		valid             bool
+	}{
+		{0, 0, true},
+		{4, 0, true},
+		{4, 2, true},
+		{3, 0, false},
+		{-2, 0, false},
+		{4, 3, false},
+	} {
+		cfg := validWHIRConfig()
+		cfg.NumShards, cfg.MerkleCapHeight = tc.shards, tc.capHeight
+		if err := cfg.Validate(); (err == nil) != tc.valid {
+			t.Errorf("num_shards %d with merkle_cap_height %d: Validate returned %v", tc.shards, tc.capHeight, err)
+		}
+	}
+}
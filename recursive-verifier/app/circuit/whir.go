@@ -1,6 +1,7 @@
 package circuit
 
 import (
+	"fmt"
 	"math/big"
 	"reilabs/whir-verifier-circuit/app/utilities"
 
@@ -10,23 +11,29 @@ import (
 	skyscraper "github.com/reilabs/gnark-skyscraper"
 )
 
+// defaultWhirSumcheckDegree is the degree of the WHIR folding sumcheck round
+// polynomials when the configuration does not specify one.
+const defaultWhirSumcheckDegree = 2
+
 // NewWhirParams creates a new WHIRParams instance from the given configuration.
 // It processes the folding factors and calculates domain sizes based on the provided config.
 func NewWhirParams(cfg WHIRConfig) WHIRParams {
 	startingDomainGen, _ := new(big.Int).SetString(cfg.DomainGenerator, 10)
 	mvParamsNumberOfVariables := cfg.NVars
 	var foldingFactor []int
-	var finalSumcheckRounds int
 
 	if len(cfg.FoldingFactor) > 1 {
 		foldingFactor = append(cfg.FoldingFactor, cfg.FoldingFactor[len(cfg.FoldingFactor)-1])
-		finalSumcheckRounds = mvParamsNumberOfVariables % foldingFactor[len(foldingFactor)-1]
 	} else {
 		foldingFactor = []int{4}
-		finalSumcheckRounds = mvParamsNumberOfVariables % 4
 	}
 	domainSize := (2 << mvParamsNumberOfVariables) * (1 << cfg.Rate) / 2
 
+	sumcheckDegree := cfg.SumcheckDegree
+	if sumcheckDegree == 0 {
+		sumcheckDegree = defaultWhirSumcheckDegree
+	}
+
 	return WHIRParams{
 		ParamNRounds:                         cfg.NRounds,
 		FoldingFactorArray:                   foldingFactor,
@@ -39,12 +46,81 @@ func NewWhirParams(cfg WHIRConfig) WHIRParams {
 		StartingDomainBackingDomainGenerator: *startingDomainGen,
 		DomainSize:                           domainSize,
 		CommittmentOODSamples:                1,
-		FinalSumcheckRounds:                  finalSumcheckRounds,
+		FinalSumcheckRounds:                  cfg.finalSumcheckRounds(),
 		MVParamsNumberOfVariables:            mvParamsNumberOfVariables,
 		BatchSize:                            cfg.BatchSize,
+		SumcheckDegree:                       sumcheckDegree,
+		OODHashToField:                       cfg.OODHashToField,
+		FoldingVariableOrder:                 cfg.FoldingVariableOrder,
+		MerkleCapSize:                        cfg.merkleCapSize(),
+		RoundOpensFirst:                      cfg.roundOpensFirst,
+		MultilinearStatement:                 cfg.MultilinearStatement,
+		CombinationRandomness:                cfg.CombinationRandomness,
+		LeafLayout:                           cfg.LeafLayout,
+		ChallengeReduction:                   cfg.ChallengeReduction,
 	}
 }
 
+// merkleCapSize is the number of Merkle cap nodes the commitments send. A commitment
+// sharded into a forest of num_shards equally sized trees is verified as a cap of
+// its shard roots: the forest commitment is the list of roots absorbed into the
+// transcript, and the top bits of a query index select the shard whose root its
+// path must reach, the rest being the index within the shard.
+func (cfg WHIRConfig) merkleCapSize() int {
+	if cfg.NumShards > 0 {
+		return cfg.NumShards
+	}
+	return 1 << cfg.MerkleCapHeight
+}
+
+// sumcheckDegreeBound is the degree the WHIR sumcheck round polynomials may have.
+// Each round polynomial is the product of the committed polynomial, which is
+// multilinear, and the weights, which include the statement. A multilinear statement
+// therefore bounds the degree by 2 even if the proof sends the round polynomials
+// with more evaluations, which would otherwise let a statement of any degree pass.
+func (params WHIRParams) sumcheckDegreeBound() int {
+	if params.MultilinearStatement {
+		return min(params.SumcheckDegree, 2)
+	}
+	return params.SumcheckDegree
+}
+
+// opensBeforeCommitting reports whether round r checks the queries to the current
+// polynomial before reading the commitment to the next folded one. WHIR commits
+// first; interleaved variants open first.
+func (params WHIRParams) opensBeforeCommitting(r int) bool {
+	return r < len(params.RoundOpensFirst) && params.RoundOpensFirst[r]
+}
+
+// Orders in which the folding challenges map to the variables of the committed
+// polynomial.
+const (
+	// reversedFoldingOrder assigns the first folding challenge to the last variable,
+	// so the evaluation point is the folding randomness in reverse. This is how the
+	// ProveKit prover folds.
+	reversedFoldingOrder = "reversed"
+	// inOrderFoldingOrder assigns the first folding challenge to the first variable.
+	inOrderFoldingOrder = "in_order"
+)
+
+// orderFoldingRandomness turns the folding challenges, in the order they were
+// squeezed, into the point at which the committed polynomial is evaluated.
+func orderFoldingRandomness(foldingRandomness []frontend.Variable, order string) []frontend.Variable {
+	if order == inOrderFoldingOrder {
+		return foldingRandomness
+	}
+	return utilities.Reverse(foldingRandomness)
+}
+
+// finalSumcheckRounds returns the number of variables left after the last full fold,
+// which the final phase of the proof removes with plain sumcheck rounds.
+func (cfg WHIRConfig) finalSumcheckRounds() int {
+	if len(cfg.FoldingFactor) > 1 {
+		return cfg.NVars % cfg.FoldingFactor[len(cfg.FoldingFactor)-1]
+	}
+	return cfg.NVars % 4
+}
+
 // RunZKWhir executes the zero-knowledge WHIR protocol for proof verification.
 // It processes multiple rounds of sumcheck protocols and merkle tree verifications
 // to verify the given circuit proof against the provided parameters.
@@ -61,9 +137,18 @@ func RunZKWhir(
 	batchingRandomness frontend.Variable,
 	initialOODQueries []frontend.Variable,
 	initialOODAnswers [][]frontend.Variable,
-	rootHashes frontend.Variable,
+	rootHashes []frontend.Variable,
 ) (totalFoldingRandomness []frontend.Variable, err error) {
 
+	foldSize := 1 << whirParams.FoldingFactorArray[0]
+	if err = checkBatchedLeafLayout(firstRound.Leaves[0], foldSize, whirParams.BatchSize, whirParams.NumStatements, linearStatementEvaluations, initialOODAnswers); err != nil {
+		return
+	}
+	if len(linearStatementValuesAtPoints) != whirParams.NumStatements {
+		err = fmt.Errorf("%d deferred statement values given, expected %d", len(linearStatementValuesAtPoints), whirParams.NumStatements)
+		return
+	}
+
 	initialOODs := oodAnswers(api, initialOODAnswers, batchingRandomness)
 	// batchSizeLen := whirParams.BatchSize
 
@@ -85,8 +170,8 @@ func RunZKWhir(
 
 	roundAnswers := make([][][]frontend.Variable, len(circuit.Leaves)+1)
 
-	foldSize := 1 << whirParams.FoldingFactorArray[0]
-	collapsed := rlcBatchedLeaves(api, firstRound.Leaves[0], foldSize, whirParams.BatchSize, batchingRandomness)
+	batchedLeaves := separateBatchedLeaves(firstRound.Leaves[0], foldSize, whirParams.BatchSize, whirParams.LeafLayout)
+	collapsed := rlcBatchedLeaves(api, batchedLeaves, foldSize, whirParams.BatchSize, batchingRandomness)
 	roundAnswers[0] = collapsed
 
 	for i := range len(circuit.Leaves) {
@@ -101,72 +186,94 @@ func RunZKWhir(
 
 	totalFoldingRandomness = initialSumcheckFoldingRandomness
 
-	rootHashList := make([]frontend.Variable, len(whirParams.RoundParametersOODSamples))
+	rootHashList := make([][]frontend.Variable, len(whirParams.RoundParametersOODSamples))
 
 	for r := range whirParams.ParamNRounds {
-		rootHash := make([]frontend.Variable, 1)
-		if err = arthur.FillNextScalars(rootHash); err != nil {
-			return
-		}
 		var roundOODAnswers []frontend.Variable
 
-		rootHashList[r] = rootHash[0]
-		mainRoundData.OODPoints[r], roundOODAnswers, err = fillInOODPointsAndAnswers(whirParams.RoundParametersOODSamples[r], arthur)
-		if err != nil {
-			return
-		}
-
-		if err = RunPoW(api, sc, arthur, whirParams.PowBits[r]); err != nil {
-			return
-		}
-
-		mainRoundData.StirChallengesPoints[r], err = getStirChallenges(api, arthur, whirParams.RoundParametersNumOfQueries[r], domainSize, 1<<whirParams.FoldingFactorArray[r])
-		if err != nil {
-			return
-		}
-
-		if r == 0 {
-			err = verifyMerkleTreeProofs(api, uapi, sc, firstRound.LeafIndexes[0], firstRound.Leaves[0], firstRound.LeafSiblingHashes[0], firstRound.AuthPaths[0], rootHashes)
+		// commit reads the commitment to the round's folded polynomial and its
+		// OOD samples; open checks the queried leaves of the current one.
+		commit := func() (err error) {
+			rootHashList[r], err = fillInMerkleCap(arthur, whirParams.MerkleCapSize)
 			if err != nil {
 				return
 			}
-
-			err = utilities.IsSubset(api, uapi, arthur, mainRoundData.StirChallengesPoints[r], firstRound.LeafIndexes[0])
+			mainRoundData.OODPoints[r], roundOODAnswers, err = fillInOODPointsAndAnswers(api, arthur, whirParams.RoundParametersOODSamples[r], whirParams.OODHashToField)
 			if err != nil {
 				return
 			}
-
-			mainRoundData.StirChallengesPoints[r] = make([]frontend.Variable, len(firstRound.LeafIndexes[r]))
-			for index := range firstRound.LeafIndexes[r] {
-				mainRoundData.StirChallengesPoints[r][index] = utilities.Exponent(api, uapi, expDomainGenerator, firstRound.LeafIndexes[r][index])
-			}
-		} else {
-			err = verifyMerkleTreeProofs(api, uapi, sc, circuit.LeafIndexes[r-1], roundAnswers[r], circuit.LeafSiblingHashes[r-1], circuit.AuthPaths[r-1], rootHashList[r-1])
-			if err != nil {
+			// The round's OOD constraints are only independent if its points differ from
+			// each other and from the OOD points of the initial commitment.
+			utilities.AssertDistinct(api, append(append([]frontend.Variable{}, initialOODQueries...), mainRoundData.OODPoints[r]...))
+			return nil
+		}
+		open := func() (err error) {
+			if err = RunPoW(api, sc, arthur, whirParams.PowBits[r]); err != nil {
 				return
 			}
-			err = utilities.IsSubset(api, uapi, arthur, mainRoundData.StirChallengesPoints[r], circuit.LeafIndexes[r-1])
+
+			mainRoundData.StirChallengesPoints[r], err = getStirChallenges(api, arthur, whirParams.RoundParametersNumOfQueries[r], domainSize, 1<<whirParams.FoldingFactorArray[r])
 			if err != nil {
 				return
 			}
-			mainRoundData.StirChallengesPoints[r] = make([]frontend.Variable, len(circuit.LeafIndexes[r-1]))
-			for index := range circuit.LeafIndexes[r-1] {
-				mainRoundData.StirChallengesPoints[r][index] = utilities.Exponent(api, uapi, expDomainGenerator, circuit.LeafIndexes[r-1][index])
+
+			if r == 0 {
+				err = verifyMerkleTreeProofs(api, uapi, sc, firstRound.LeafIndexes[0], firstRound.Leaves[0], firstRound.LeafSiblingHashes[0], firstRound.AuthPaths[0], rootHashes)
+				if err != nil {
+					return
+				}
+
+				err = utilities.AssertOpensQueries(api, uapi, arthur, mainRoundData.StirChallengesPoints[r], firstRound.LeafIndexes[0])
+				if err != nil {
+					return
+				}
+
+				mainRoundData.StirChallengesPoints[r] = make([]frontend.Variable, len(firstRound.LeafIndexes[r]))
+				for index := range firstRound.LeafIndexes[r] {
+					mainRoundData.StirChallengesPoints[r][index] = utilities.Exponent(api, uapi, expDomainGenerator, firstRound.LeafIndexes[r][index])
+				}
+			} else {
+				err = verifyMerkleTreeProofs(api, uapi, sc, circuit.LeafIndexes[r-1], roundAnswers[r], circuit.LeafSiblingHashes[r-1], circuit.AuthPaths[r-1], rootHashList[r-1])
+				if err != nil {
+					return
+				}
+				err = utilities.AssertOpensQueries(api, uapi, arthur, mainRoundData.StirChallengesPoints[r], circuit.LeafIndexes[r-1])
+				if err != nil {
+					return
+				}
+				mainRoundData.StirChallengesPoints[r] = make([]frontend.Variable, len(circuit.LeafIndexes[r-1]))
+				for index := range circuit.LeafIndexes[r-1] {
+					mainRoundData.StirChallengesPoints[r][index] = utilities.Exponent(api, uapi, expDomainGenerator, circuit.LeafIndexes[r-1][index])
+				}
 			}
+			return nil
 		}
 
-		mainRoundData.CombinationRandomness[r], err = GenerateCombinationRandomness(api, arthur, len(mainRoundData.OODPoints[r])+len(computedFold))
+		first, second := commit, open
+		if whirParams.opensBeforeCommitting(r) {
+			first, second = open, commit
+		}
+		if err = first(); err != nil {
+			return
+		}
+		if err = second(); err != nil {
+			return
+		}
+
+		mainRoundData.CombinationRandomness[r], err = GenerateCombinationRandomness(api, arthur, len(mainRoundData.OODPoints[r])+len(computedFold), whirParams.CombinationRandomness, whirParams.ChallengeReduction)
 		if err != nil {
 			return
 		}
 
-		lastEval = api.Add(lastEval, calculateShiftValue(roundOODAnswers, mainRoundData.CombinationRandomness[r], computedFold, api))
+		claim := nextRoundClaim(api, lastEval, roundOODAnswers, mainRoundData.CombinationRandomness[r], computedFold)
 
 		var roundFoldingRandomness []frontend.Variable
-		roundFoldingRandomness, lastEval, err = runWhirSumcheckRounds(api, lastEval, arthur, whirParams.FoldingFactorArray[r], 3)
+		var claimedSum frontend.Variable
+		roundFoldingRandomness, claimedSum, lastEval, err = runWhirSumcheckRounds(api, arthur, whirParams.FoldingFactorArray[r], whirParams.SumcheckDegree, whirParams.sumcheckDegreeBound(), whirParams.ChallengeReduction)
 		if err != nil {
 			return
 		}
+		assertClaimChained(api, claim, claimedSum)
 
 		computedFold = computeFold(circuit.Leaves[r], roundFoldingRandomness, api)
 		totalFoldingRandomness = append(totalFoldingRandomness, roundFoldingRandomness...)
@@ -186,10 +293,14 @@ func RunZKWhir(
 		api.AssertIsEqual(computedFold[foldIndex], finalEvaluations[foldIndex])
 	}
 
-	finalSumcheckRandomness, lastEval, err := runWhirSumcheckRounds(api, lastEval, arthur, whirParams.FinalSumcheckRounds, 3)
+	finalSumcheckRandomness, finalClaimedSum, finalValue, err := runWhirSumcheckRounds(api, arthur, whirParams.FinalSumcheckRounds, whirParams.SumcheckDegree, whirParams.sumcheckDegreeBound(), whirParams.ChallengeReduction)
 	if err != nil {
 		return
 	}
+	if whirParams.FinalSumcheckRounds > 0 {
+		assertClaimChained(api, lastEval, finalClaimedSum)
+		lastEval = finalValue
+	}
 
 	totalFoldingRandomness = append(totalFoldingRandomness, finalSumcheckRandomness...)
 
@@ -200,7 +311,10 @@ func RunZKWhir(
 		}
 	}
 
-	totalFoldingRandomness = utilities.Reverse(totalFoldingRandomness)
+	totalFoldingRandomness = orderFoldingRandomness(totalFoldingRandomness, whirParams.FoldingVariableOrder)
+	if err = checkPointDimension(totalFoldingRandomness, whirParams); err != nil {
+		return
+	}
 
 	evaluationOfWPoly := computeWPoly(
 		api,
@@ -211,14 +325,61 @@ func RunZKWhir(
 		linearStatementValuesAtPoints,
 	)
 
-	api.AssertIsEqual(
+	err = assertFinalEvaluation(
+		api,
+		whirParams.ReportMismatches,
 		lastEval,
 		api.Mul(evaluationOfWPoly, utilities.MultivarPoly(finalCoefficients, finalSumcheckRandomness, api)),
 	)
+	if err != nil {
+		return
+	}
 
 	return totalFoldingRandomness, nil
 }
 
+// VerifyWithPublicInputs runs RunZKWhir after binding the proof to publicInputs, as
+// needed when an outer recursion circuit fixes the commitment and statement. The
+// expected layout is the commitment's Merkle cap (a single root unless the config
+// sets a cap height), followed by the statement values at the random point in the
+// order RunZKWhir receives them.
+func VerifyWithPublicInputs(
+	api frontend.API,
+	arthur gnarkNimue.Arthur,
+	uapi *uints.BinaryField[uints.U64],
+	sc *skyscraper.Skyscraper,
+	circuit Merkle,
+	firstRound Merkle,
+	whirParams WHIRParams,
+	publicInputs []frontend.Variable,
+	linearStatementEvaluations [][]frontend.Variable,
+	linearStatementValuesAtPoints []frontend.Variable,
+	batchingRandomness frontend.Variable,
+	initialOODQueries []frontend.Variable,
+	initialOODAnswers [][]frontend.Variable,
+	rootHashes []frontend.Variable,
+) ([]frontend.Variable, error) {
+	if err := bindPublicInputs(api, publicInputs, rootHashes, linearStatementValuesAtPoints); err != nil {
+		return nil, err
+	}
+	return RunZKWhir(api, arthur, uapi, sc, circuit, firstRound, whirParams, linearStatementEvaluations, linearStatementValuesAtPoints, batchingRandomness, initialOODQueries, initialOODAnswers, rootHashes)
+}
+
+// bindPublicInputs asserts that publicInputs are the Merkle cap rootHashes followed
+// by the statement values, the layout VerifyWithPublicInputs expects.
+func bindPublicInputs(api frontend.API, publicInputs []frontend.Variable, rootHashes []frontend.Variable, statementValues []frontend.Variable) error {
+	if len(publicInputs) != len(rootHashes)+len(statementValues) {
+		return fmt.Errorf("expected %d public inputs (%d cap nodes and %d statement values), got %d", len(rootHashes)+len(statementValues), len(rootHashes), len(statementValues), len(publicInputs))
+	}
+	for i, root := range rootHashes {
+		api.AssertIsEqual(publicInputs[i], root)
+	}
+	for i, value := range statementValues {
+		api.AssertIsEqual(publicInputs[len(rootHashes)+i], value)
+	}
+	return nil
+}
+
 //nolint:unused
 func runWhir(
 	api frontend.API,
@@ -230,30 +391,30 @@ func runWhir(
 	linearStatementEvaluations []frontend.Variable,
 	linearStatementValuesAtPoints []frontend.Variable,
 ) (totalFoldingRandomness []frontend.Variable, err error) {
-	if err = fillInAndVerifyRootHash(0, api, uapi, sc, circuit, arthur); err != nil {
+	if err = fillInAndVerifyRootHash(0, api, uapi, sc, circuit, arthur, whirParams.MerkleCapSize); err != nil {
 		return
 	}
 
-	initialOODQueries, initialOODAnswers, tempErr := fillInOODPointsAndAnswers(whirParams.CommittmentOODSamples, arthur)
+	initialOODQueries, initialOODAnswers, tempErr := fillInOODPointsAndAnswers(api, arthur, whirParams.CommittmentOODSamples, whirParams.OODHashToField)
 	if tempErr != nil {
 		err = tempErr
 		return
 	}
 
-	initialCombinationRandomness, tempErr := GenerateCombinationRandomness(api, arthur, whirParams.CommittmentOODSamples+len(linearStatementEvaluations))
+	initialCombinationRandomness, tempErr := GenerateCombinationRandomness(api, arthur, whirParams.CommittmentOODSamples+len(linearStatementEvaluations), whirParams.CombinationRandomness, whirParams.ChallengeReduction)
 	if tempErr != nil {
 		err = tempErr
 		return
 	}
 
-	OODAnswersAndStatmentEvaluations := append(initialOODAnswers, linearStatementEvaluations...)
-	lastEval := utilities.DotProduct(api, initialCombinationRandomness, OODAnswersAndStatmentEvaluations)
-
-	initialSumcheckFoldingRandomness, lastEval, tempErr := runWhirSumcheckRounds(api, lastEval, arthur, whirParams.FoldingFactorArray[0], 3)
+	initialSumcheckFoldingRandomness, initialClaimedSum, lastEval, tempErr := runWhirSumcheckRounds(api, arthur, whirParams.FoldingFactorArray[0], whirParams.SumcheckDegree, whirParams.sumcheckDegreeBound(), whirParams.ChallengeReduction)
 	if tempErr != nil {
 		err = tempErr
 		return
 	}
+	if err = AssertInitialSumcheckClaim(api, initialOODAnswers, linearStatementEvaluations, initialCombinationRandomness, initialClaimedSum); err != nil {
+		return
+	}
 
 	initialData := InitialSumcheckData{
 		InitialOODQueries:            initialOODQueries,
@@ -270,12 +431,12 @@ func runWhir(
 	totalFoldingRandomness = initialSumcheckFoldingRandomness
 
 	for r := range whirParams.ParamNRounds {
-		if err = fillInAndVerifyRootHash(r+1, api, uapi, sc, circuit, arthur); err != nil {
+		if err = fillInAndVerifyRootHash(r+1, api, uapi, sc, circuit, arthur, whirParams.MerkleCapSize); err != nil {
 			return
 		}
 
 		var roundOODAnswers []frontend.Variable
-		mainRoundData.OODPoints[r], roundOODAnswers, err = fillInOODPointsAndAnswers(whirParams.RoundParametersOODSamples[r], arthur)
+		mainRoundData.OODPoints[r], roundOODAnswers, err = fillInOODPointsAndAnswers(api, arthur, whirParams.RoundParametersOODSamples[r], whirParams.OODHashToField)
 		if err != nil {
 			return
 		}
@@ -289,18 +450,20 @@ func runWhir(
 			return
 		}
 
-		mainRoundData.CombinationRandomness[r], err = GenerateCombinationRandomness(api, arthur, len(circuit.LeafIndexes[r])+whirParams.RoundParametersOODSamples[r])
+		mainRoundData.CombinationRandomness[r], err = GenerateCombinationRandomness(api, arthur, len(circuit.LeafIndexes[r])+whirParams.RoundParametersOODSamples[r], whirParams.CombinationRandomness, whirParams.ChallengeReduction)
 		if err != nil {
 			return
 		}
 
-		lastEval = api.Add(lastEval, calculateShiftValue(roundOODAnswers, mainRoundData.CombinationRandomness[r], computedFold, api))
+		claim := nextRoundClaim(api, lastEval, roundOODAnswers, mainRoundData.CombinationRandomness[r], computedFold)
 
 		var roundFoldingRandomness []frontend.Variable
-		roundFoldingRandomness, lastEval, err = runWhirSumcheckRounds(api, lastEval, arthur, whirParams.FoldingFactorArray[r], 3)
+		var claimedSum frontend.Variable
+		roundFoldingRandomness, claimedSum, lastEval, err = runWhirSumcheckRounds(api, arthur, whirParams.FoldingFactorArray[r], whirParams.SumcheckDegree, whirParams.sumcheckDegreeBound(), whirParams.ChallengeReduction)
 		if err != nil {
 			return
 		}
+		assertClaimChained(api, claim, claimedSum)
 
 		computedFold = computeFold(circuit.Leaves[r+1], roundFoldingRandomness, api)
 		totalFoldingRandomness = append(totalFoldingRandomness, roundFoldingRandomness...)
@@ -329,15 +492,22 @@ func runWhir(
 		api.AssertIsEqual(computedFold[foldIndex], finalEvaluations[foldIndex])
 	}
 
-	finalSumcheckRandomness, lastEval, tempErr := runWhirSumcheckRounds(api, lastEval, arthur, whirParams.FinalSumcheckRounds, 3)
+	finalSumcheckRandomness, finalClaimedSum, finalValue, tempErr := runWhirSumcheckRounds(api, arthur, whirParams.FinalSumcheckRounds, whirParams.SumcheckDegree, whirParams.sumcheckDegreeBound(), whirParams.ChallengeReduction)
 	if tempErr != nil {
 		err = tempErr
 		return
 	}
+	if whirParams.FinalSumcheckRounds > 0 {
+		assertClaimChained(api, lastEval, finalClaimedSum)
+		lastEval = finalValue
+	}
 
 	totalFoldingRandomness = append(totalFoldingRandomness, finalSumcheckRandomness...)
 
-	totalFoldingRandomness = utilities.Reverse(totalFoldingRandomness)
+	totalFoldingRandomness = orderFoldingRandomness(totalFoldingRandomness, whirParams.FoldingVariableOrder)
+	if err = checkPointDimension(totalFoldingRandomness, whirParams); err != nil {
+		return
+	}
 
 	evaluationOfVPoly := computeWPoly(
 		api,
@@ -348,17 +518,23 @@ func runWhir(
 		linearStatementValuesAtPoints,
 	)
 
-	api.AssertIsEqual(
+	err = assertFinalEvaluation(
+		api,
+		whirParams.ReportMismatches,
 		lastEval,
 		api.Mul(evaluationOfVPoly, utilities.MultivarPoly(finalCoefficients, finalSumcheckRandomness, api)),
 	)
-
-	err = nil
 	return
 }
 
 // RunPoW executes a proof-of-work challenge if the difficulty is greater than zero.
 // This is used as part of the Fiat-Shamir transformation to prevent malicious prover behavior.
+//
+// Nonces are deliberately not required to differ between rounds, or from the final
+// folding PoW. Every challenge is squeezed from a transcript that has absorbed all
+// earlier nonces, so a reused nonce must still be ground anew against a fresh
+// challenge and buys the prover nothing. Rejecting reuse would only fail honest
+// proofs whose grinding happened to land on the same nonce twice.
 func RunPoW(api frontend.API, sc *skyscraper.Skyscraper, arthur gnarkNimue.Arthur, difficulty int) error {
 	if difficulty > 0 {
 		_, _, err := utilities.PoW(api, sc, arthur, difficulty)
@@ -387,7 +563,7 @@ func GenerateStirChallengePoints(
 		return nil, err
 	}
 
-	err = utilities.IsSubset(api, uapi, arthur, finalIndexes, leafIndexes)
+	err = utilities.AssertOpensQueries(api, uapi, arthur, finalIndexes, leafIndexes)
 	if err != nil {
 		return nil, err
 	}
@@ -401,15 +577,34 @@ func GenerateStirChallengePoints(
 	return finalRandomnessPoints, nil
 }
 
-// GenerateCombinationRandomness generates the combination randomness for the given parameters.
-// It generates a random scalar and expands it to the required length.
-func GenerateCombinationRandomness(api frontend.API, arthur gnarkNimue.Arthur, randomnessLength int) ([]frontend.Variable, error) {
-	combRandomnessGen := make([]frontend.Variable, 1)
-	if err := arthur.FillChallengeScalars(combRandomnessGen); err != nil {
-		return nil, err
-	}
-
-	combinationRandomness := utilities.ExpandRandomness(api, combRandomnessGen[0], randomnessLength)
-	return combinationRandomness, nil
+// How the combination randomness of a WHIR round is derived from the transcript.
+const (
+	// powersCombinationRandomness squeezes a single challenge r and combines the
+	// constraints with 1, r, r^2, ..., so the transcript carries one scalar per
+	// round however many constraints are combined. This is the default.
+	powersCombinationRandomness = "powers"
+	// independentCombinationRandomness squeezes one challenge per constraint.
+	independentCombinationRandomness = "independent"
+)
 
+// GenerateCombinationRandomness derives randomnessLength combination coefficients
+// from the transcript using the given method, squeezing the challenges with the
+// given challenge_reduction method.
+func GenerateCombinationRandomness(api frontend.API, arthur gnarkNimue.Arthur, randomnessLength int, method string, challengeReduction string) ([]frontend.Variable, error) {
+	switch method {
+	case "", powersCombinationRandomness:
+		combRandomnessGen := make([]frontend.Variable, 1)
+		if err := squeezeChallenges(api, arthur, challengeReduction, combRandomnessGen); err != nil {
+			return nil, err
+		}
+		return utilities.ExpandRandomness(api, combRandomnessGen[0], randomnessLength), nil
+	case independentCombinationRandomness:
+		combinationRandomness := make([]frontend.Variable, randomnessLength)
+		if err := squeezeChallenges(api, arthur, challengeReduction, combinationRandomness); err != nil {
+			return nil, err
+		}
+		return combinationRandomness, nil
+	default:
+		return nil, fmt.Errorf("unknown combination randomness method %q", method)
+	}
 }
@@ -0,0 +1,143 @@
+package circuit
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/test"
+	gnarkNimue "github.com/reilabs/gnark-nimue"
+	skyscraper "github.com/reilabs/gnark-skyscraper"
+)
+
+// combinationRandomnessCircuit derives len(Expected) combination coefficients with
+// Method from the probe transcript Transcript and checks them against Expected.
+type combinationRandomnessCircuit struct {
+	Method     string
+	Transcript []uints.U8
+	Expected   []frontend.Variable
+}
+
+func (c *combinationRandomnessCircuit) Define(api frontend.API) error {
+	arthur, err := gnarkNimue.NewSkyscraperArthur(api, skyscraper.NewSkyscraper(api, 2), challengeProbeIO, c.Transcript, true)
+	if err != nil {
+		return err
+	}
+	if err := arthur.FillNextScalars(make([]frontend.Variable, 1)); err != nil {
+		return err
+	}
+	randomness, err := GenerateCombinationRandomness(api, arthur, len(c.Expected), c.Method, "")
+	if err != nil {
+		return err
+	}
+	for i := range randomness {
+		api.AssertIsEqual(randomness[i], c.Expected[i])
+	}
+	return nil
+}
+
+func TestGenerateCombinationRandomness(t *testing.T) {
+	const length = 3
+	transcript := seedTranscript(271828)
+	scalars := squeezedScalars(t, challengeProbeIO, length, transcript)
+
+	// Powers combine with 1, r, r^2 for the first challenge r; independent
+	// coefficients are the first three challenges themselves.
+	modulus := ecc.BN254.ScalarField()
+	r := scalars[0]
+	powers := []frontend.Variable{big.NewInt(1), new(big.Int).Set(r), new(big.Int).Exp(r, big.NewInt(2), modulus)}
+	independent := []frontend.Variable{scalars[0], scalars[1], scalars[2]}
+
+	shape := func(method string) *combinationRandomnessCircuit {
+		return &combinationRandomnessCircuit{Method: method, Transcript: make([]uints.U8, len(transcript)), Expected: make([]frontend.Variable, length)}
+	}
+	for _, tc := range []struct {
+		name     string
+		method   string
+		expected []frontend.Variable
+		valid    bool
+	}{
+		{"powers", powersCombinationRandomness, powers, true},
+		{"default", "", powers, true},
+		{"independent", independentCombinationRandomness, independent, true},
+		{"powers against independent coefficients", powersCombinationRandomness, independent, false},
+		{"independent against powers", independentCombinationRandomness, powers, false},
+	} {
+		assignment := &combinationRandomnessCircuit{Method: tc.method, Transcript: transcript, Expected: tc.expected}
+		err := test.IsSolved(shape(tc.method), assignment, ecc.BN254.ScalarField())
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: IsSolved returned %v", tc.name, err)
+		}
+	}
+
+	// A different transcript derives different coefficients.
+	other := &combinationRandomnessCircuit{Method: powersCombinationRandomness, Transcript: seedTranscript(314159), Expected: powers}
+	if err := test.IsSolved(shape(powersCombinationRandomness), other, ecc.BN254.ScalarField()); err == nil {
+		t.Error("powers of another transcript's challenge accepted")
+	}
+}
+
+func TestGenerateCombinationRandomnessRejectsAnUnknownMethod(t *testing.T) {
+	transcript := seedTranscript(1)
+	circuit := &combinationRandomnessCircuit{Method: "squared", Transcript: make([]uints.U8, len(transcript)), Expected: make([]frontend.Variable, 3)}
+	assignment := &combinationRandomnessCircuit{Method: "squared", Transcript: transcript, Expected: []frontend.Variable{1, 1, 1}}
+	if err := test.IsSolved(circuit, assignment, ecc.BN254.ScalarField()); err == nil {
+		t.Fatal("unknown combination randomness method accepted")
+	}
+}
+
+// boundInputsCircuit binds Root and Values to the public inputs Inputs.
+type boundInputsCircuit struct {
+	Inputs []frontend.Variable `gnark:",public"`
+	Root   []frontend.Variable
+	Values []frontend.Variable
+}
+
+func (c *boundInputsCircuit) Define(api frontend.API) error {
+	return bindPublicInputs(api, c.Inputs, c.Root, c.Values)
+}
+
+func TestBindPublicInputs(t *testing.T) {
+	shape := &boundInputsCircuit{Inputs: make([]frontend.Variable, 3), Root: make([]frontend.Variable, 1), Values: make([]frontend.Variable, 2)}
+	honest := func() *boundInputsCircuit {
+		return &boundInputsCircuit{Inputs: []frontend.Variable{7, 11, 13}, Root: []frontend.Variable{7}, Values: []frontend.Variable{11, 13}}
+	}
+	if err := test.IsSolved(shape, honest(), ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("matching public inputs rejected: %v", err)
+	}
+	for i := range 3 {
+		changed := honest()
+		changed.Inputs[i] = 17
+		if err := test.IsSolved(shape, changed, ecc.BN254.ScalarField()); err == nil {
+			t.Errorf("changed public input %d accepted", i)
+		}
+	}
+
+	short := &boundInputsCircuit{Inputs: make([]frontend.Variable, 2), Root: make([]frontend.Variable, 1), Values: make([]frontend.Variable, 2)}
+	if err := test.IsSolved(short, &boundInputsCircuit{Inputs: []frontend.Variable{7, 11}, Root: []frontend.Variable{7}, Values: []frontend.Variable{11, 13}}, ecc.BN254.ScalarField()); err == nil {
+		t.Error("public inputs missing a statement value accepted")
+	}
+}
+
+func TestOrderFoldingRandomness(t *testing.T) {
+	squeezed := []frontend.Variable{1, 2, 3}
+	for _, tc := range []struct {
+		order    string
+		expected []frontend.Variable
+	}{
+		{"", []frontend.Variable{3, 2, 1}},
+		{reversedFoldingOrder, []frontend.Variable{3, 2, 1}},
+		{inOrderFoldingOrder, []frontend.Variable{1, 2, 3}},
+	} {
+		if point := orderFoldingRandomness(squeezed, tc.order); !reflect.DeepEqual(point, tc.expected) {
+			t.Errorf("order %q: point %v, expected %v", tc.order, point, tc.expected)
+		}
+	}
+	// The challenges are reordered in a copy.
+	if !reflect.DeepEqual(squeezed, []frontend.Variable{1, 2, 3}) {
+		t.Errorf("squeezed challenges reordered in place to %v", squeezed)
+	}
+}
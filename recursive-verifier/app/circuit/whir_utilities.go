@@ -1,48 +1,75 @@
 package circuit
 
 import (
+	"fmt"
+	"math/big"
 	"math/bits"
 	"reilabs/whir-verifier-circuit/app/utilities"
 
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/std/selector"
 	gnarkNimue "github.com/reilabs/gnark-nimue"
 	skyscraper "github.com/reilabs/gnark-skyscraper"
 )
 
-func verifyMerkleTreeProofs(api frontend.API, uapi *uints.BinaryField[uints.U64], sc *skyscraper.Skyscraper, leafIndexes []uints.U64, leaves [][]frontend.Variable, leafSiblingHashes []frontend.Variable, authPaths [][]frontend.Variable, rootHash frontend.Variable) error {
-	numOfLeavesProved := len(leaves)
-	for i := range numOfLeavesProved {
-		treeHeight := len(authPaths[i]) + 1
-		leafIndexBits := api.ToBinary(uapi.ToValue(leafIndexes[i]), treeHeight)
-		leafSiblingHash := leafSiblingHashes[i]
+// verifyMerkleTreeProofs checks the opened leaves against the Merkle cap, the
+// 2^h nodes at height h below the root. A cap of a single node is the root itself.
+// The auth paths stop at the cap, and the top h bits of each leaf index select the
+// cap node its path must reach.
+func verifyMerkleTreeProofs(api frontend.API, uapi *uints.BinaryField[uints.U64], sc *skyscraper.Skyscraper, leafIndexes []uints.U64, leaves [][]frontend.Variable, leafSiblingHashes []frontend.Variable, authPaths [][]frontend.Variable, merkleCap []frontend.Variable) error {
+	capHeight := bits.Len(uint(len(merkleCap))) - 1
+	if capHeight < 0 || len(merkleCap) != 1<<capHeight {
+		return fmt.Errorf("merkle cap has %d nodes, expected a power of two", len(merkleCap))
+	}
+	for i := range leaves {
+		verifyMerklePath(api, uapi, sc, leafIndexes[i], leaves[i], leafSiblingHashes[i], authPaths[i], merkleCap, capHeight)
+	}
+	return nil
+}
 
-		claimedLeafHash := sc.CompressV2(leaves[i][0], leaves[i][1])
-		for x := range len(leaves[i]) - 2 {
-			claimedLeafHash = sc.CompressV2(claimedLeafHash, leaves[i][x+2])
-		}
+// verifyMerklePath checks a single opened leaf against the Merkle cap.
+func verifyMerklePath(api frontend.API, uapi *uints.BinaryField[uints.U64], sc *skyscraper.Skyscraper, leafIndex uints.U64, leaf []frontend.Variable, leafSiblingHash frontend.Variable, authPath []frontend.Variable, merkleCap []frontend.Variable, capHeight int) {
+	treeHeight := len(authPath) + 1
+	leafIndexBits := api.ToBinary(uapi.ToValue(leafIndex), treeHeight+capHeight)
 
-		dir := leafIndexBits[0]
+	claimedLeafHash := sc.CompressV2(leaf[0], leaf[1])
+	for x := range len(leaf) - 2 {
+		claimedLeafHash = sc.CompressV2(claimedLeafHash, leaf[x+2])
+	}
 
-		xLeftChild := api.Select(dir, leafSiblingHash, claimedLeafHash)
-		xRightChild := api.Select(dir, claimedLeafHash, leafSiblingHash)
+	dir := leafIndexBits[0]
 
-		currentHash := sc.CompressV2(xLeftChild, xRightChild)
+	xLeftChild := api.Select(dir, leafSiblingHash, claimedLeafHash)
+	xRightChild := api.Select(dir, claimedLeafHash, leafSiblingHash)
 
-		for level := 1; level < treeHeight; level++ {
-			indexBit := leafIndexBits[level]
+	currentHash := sc.CompressV2(xLeftChild, xRightChild)
 
-			siblingHash := authPaths[i][level-1]
+	for level := 1; level < treeHeight; level++ {
+		indexBit := leafIndexBits[level]
 
-			dir := api.And(indexBit, 1)
-			left := api.Select(dir, siblingHash, currentHash)
-			right := api.Select(dir, currentHash, siblingHash)
+		siblingHash := authPath[level-1]
 
-			currentHash = sc.CompressV2(left, right)
-		}
-		api.AssertIsEqual(currentHash, rootHash)
+		dir := api.And(indexBit, 1)
+		left := api.Select(dir, siblingHash, currentHash)
+		right := api.Select(dir, currentHash, siblingHash)
+
+		currentHash = sc.CompressV2(left, right)
+	}
+	if capHeight == 0 {
+		api.AssertIsEqual(currentHash, merkleCap[0])
+	} else {
+		api.AssertIsEqual(currentHash, selector.Mux(api, api.FromBinary(leafIndexBits[treeHeight:]...), merkleCap...))
 	}
-	return nil
+}
+
+// fillInMerkleCap reads a Merkle cap of capSize nodes from the transcript.
+func fillInMerkleCap(arthur gnarkNimue.Arthur, capSize int) ([]frontend.Variable, error) {
+	merkleCap := make([]frontend.Variable, capSize)
+	if err := arthur.FillNextScalars(merkleCap); err != nil {
+		return nil, err
+	}
+	return merkleCap, nil
 }
 
 func getStirChallenges(
@@ -84,11 +111,33 @@ func generateEmptyMainRoundData(circuit WHIRParams) MainRoundData {
 	}
 }
 
-func fillInOODPointsAndAnswers(numberOfOODPoints int, arthur gnarkNimue.Arthur) ([]frontend.Variable, []frontend.Variable, error) {
+// Hash-to-field methods used to derive OOD points from the transcript.
+const (
+	// nativeHashToField squeezes native field elements from the sponge.
+	nativeHashToField = "native"
+	// bytesHashToField squeezes the digest of each element and reduces it with
+	// ModularReduction, as byte-oriented provers do.
+	bytesHashToField = "bytes"
+)
+
+// squeezeFieldElements fills out with base field challenges derived using the
+// given hash-to-field method.
+func squeezeFieldElements(api frontend.API, arthur gnarkNimue.Arthur, method string, out []frontend.Variable) error {
+	switch method {
+	case "", nativeHashToField:
+		return arthur.FillChallengeScalars(out)
+	case bytesHashToField:
+		return squeezeReduced(api, arthur, ModularReduction{}, out)
+	default:
+		return fmt.Errorf("unknown hash-to-field method %q", method)
+	}
+}
+
+func fillInOODPointsAndAnswers(api frontend.API, arthur gnarkNimue.Arthur, numberOfOODPoints int, hashToField string) ([]frontend.Variable, []frontend.Variable, error) {
 	oodPoints := make([]frontend.Variable, numberOfOODPoints)
 	oodAnswers := make([]frontend.Variable, numberOfOODPoints)
 
-	if err := arthur.FillChallengeScalars(oodPoints); err != nil {
+	if err := squeezeFieldElements(api, arthur, hashToField, oodPoints); err != nil {
 		return nil, nil, err
 	}
 
@@ -99,29 +148,56 @@ func fillInOODPointsAndAnswers(numberOfOODPoints int, arthur gnarkNimue.Arthur)
 	return oodPoints, oodAnswers, nil
 }
 
+// runWhirSumcheckRounds runs foldingFactor sumcheck rounds, squeezing the folding
+// randomness with the given challenge_reduction method. It returns the folding
+// randomness, claimedSum, the sum over {0, 1} of the first round polynomial, and
+// the value the last round reduces to, both nil if there are no rounds. Every round
+// after the first is checked against the one before it, but the first is not
+// checked here: the caller asserts claimedSum against the claim the sumcheck has to
+// start from, see assertClaimChained and AssertInitialSumcheckClaim.
 func runWhirSumcheckRounds(
 	api frontend.API,
-	lastEval frontend.Variable,
 	arthur gnarkNimue.Arthur,
 	foldingFactor int,
 	polynomialDegree int,
-) ([]frontend.Variable, frontend.Variable, error) {
-	sumcheckPolynomial := make([]frontend.Variable, polynomialDegree)
+	degreeBound int,
+	challengeReduction string,
+) ([]frontend.Variable, frontend.Variable, frontend.Variable, error) {
+	// Round polynomials are sent in evaluation form at 0, 1, ..., polynomialDegree,
+	// and must have degree at most degreeBound.
+	sumcheckPolynomial := make([]frontend.Variable, polynomialDegree+1)
 	foldingRandomness := make([]frontend.Variable, foldingFactor)
 	foldingRandomnessTemp := make([]frontend.Variable, 1)
+	var claimedSum, lastEval frontend.Variable
 
 	for i := range foldingFactor {
 		if err := arthur.FillNextScalars(sumcheckPolynomial); err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
-		if err := arthur.FillChallengeScalars(foldingRandomnessTemp); err != nil {
-			return nil, nil, err
+		if err := squeezeChallenges(api, arthur, challengeReduction, foldingRandomnessTemp); err != nil {
+			return nil, nil, nil, err
 		}
 		foldingRandomness[i] = foldingRandomnessTemp[0]
-		utilities.CheckSumOverBool(api, lastEval, sumcheckPolynomial)
-		lastEval = utilities.EvaluateQuadraticPolynomialFromEvaluationList(api, sumcheckPolynomial, foldingRandomness[i])
+		if i == 0 {
+			claimedSum = api.Add(sumcheckPolynomial[0], sumcheckPolynomial[1])
+		} else {
+			utilities.CheckSumOverBool(api, lastEval, sumcheckPolynomial)
+		}
+		utilities.AssertDegreeAtMost(api, sumcheckPolynomial, degreeBound)
+		lastEval = utilities.EvaluatePolynomialFromEvaluationList(api, sumcheckPolynomial, foldingRandomness[i])
 	}
-	return foldingRandomness, lastEval, nil
+	return foldingRandomness, claimedSum, lastEval, nil
+}
+
+// checkPointDimension checks that the point the folding randomness of a WHIR proof
+// accumulates to has one coordinate per variable of the committed polynomial. The
+// rounds' folding factors and the final sumcheck must together fold every variable
+// exactly once; any other count means the folding was mis-accounted.
+func checkPointDimension(point []frontend.Variable, params WHIRParams) error {
+	if len(point) != params.MVParamsNumberOfVariables {
+		return fmt.Errorf("%w: accumulated point has %d coordinates, expected %d", ErrPointDimensionMismatch, len(point), params.MVParamsNumberOfVariables)
+	}
+	return nil
 }
 
 func computeWPoly(
@@ -165,12 +241,13 @@ func fillInAndVerifyRootHash(
 	sc *skyscraper.Skyscraper,
 	circuit Merkle,
 	arthur gnarkNimue.Arthur,
+	capSize int,
 ) error {
-	rootHash := make([]frontend.Variable, 1)
-	if err := arthur.FillNextScalars(rootHash); err != nil {
+	merkleCap, err := fillInMerkleCap(arthur, capSize)
+	if err != nil {
 		return err
 	}
-	err := verifyMerkleTreeProofs(api, uapi, sc, circuit.LeafIndexes[roundNum], circuit.Leaves[roundNum], circuit.LeafSiblingHashes[roundNum], circuit.AuthPaths[roundNum], rootHash[0])
+	err = verifyMerkleTreeProofs(api, uapi, sc, circuit.LeafIndexes[roundNum], circuit.Leaves[roundNum], circuit.LeafSiblingHashes[roundNum], circuit.AuthPaths[roundNum], merkleCap)
 	if err != nil {
 		return err
 	}
@@ -185,6 +262,54 @@ func computeFold(leaves [][]frontend.Variable, foldingRandomness []frontend.Vari
 	return computedFold
 }
 
+// assertFinalEvaluation asserts that the value the WHIR sumcheck reduced to equals
+// the evaluation implied by the final polynomial. With report set, which only
+// NativeVerify's WithMismatchReport does, the equality goes through the
+// checkFinalEvaluation hint, so a mismatch fails the solver with an error carrying
+// both values. Both assertions read the hint output, which makes the solver run the
+// hint before it checks either, and together they still force actual == expected.
+func assertFinalEvaluation(api frontend.API, report bool, actual frontend.Variable, expected frontend.Variable) error {
+	if !report {
+		api.AssertIsEqual(actual, expected)
+		return nil
+	}
+	checked, err := api.Compiler().NewHint(checkFinalEvaluation, 1, actual, expected)
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(checked[0], actual)
+	api.AssertIsEqual(checked[0], expected)
+	return nil
+}
+
+// checkFinalEvaluation returns its first input, the actual final evaluation, and
+// fails with ErrFinalEvaluationMismatch if it differs from the second, the
+// expected one.
+func checkFinalEvaluation(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
+	if inputs[0].Cmp(inputs[1]) != 0 {
+		return fmt.Errorf("%w: expected %s, got %s", ErrFinalEvaluationMismatch, inputs[1], inputs[0])
+	}
+	outputs[0].Set(inputs[0])
+	return nil
+}
+
+// nextRoundClaim links consecutive WHIR rounds: the claim the next round's sumcheck
+// starts from is the value the previous sumcheck reduced to, folded together with
+// the round's OOD answers and the folded STIR evaluations under its combination
+// randomness. The claim is derived here rather than read from the transcript.
+func nextRoundClaim(api frontend.API, previousValue frontend.Variable, oodAnswers []frontend.Variable, combinationRandomness []frontend.Variable, computedFold []frontend.Variable) frontend.Variable {
+	return api.Add(previousValue, calculateShiftValue(oodAnswers, combinationRandomness, computedFold, api))
+}
+
+// assertClaimChained asserts that a sumcheck starts from claim, the claim the
+// previous round's final value is folded into, by checking it against claimedSum,
+// the sum runWhirSumcheckRounds reads off the sumcheck's first round polynomial.
+// It is the only check on that polynomial's sum, so a prover that resets the claim
+// between rounds fails here.
+func assertClaimChained(api frontend.API, claim frontend.Variable, claimedSum frontend.Variable) {
+	api.AssertIsEqual(claimedSum, claim)
+}
+
 func calculateShiftValue(oodAnswers []frontend.Variable, combinationRandomness []frontend.Variable, computedFold []frontend.Variable, api frontend.API) frontend.Variable {
 	return utilities.DotProduct(api, append(oodAnswers, computedFold...), combinationRandomness)
 }
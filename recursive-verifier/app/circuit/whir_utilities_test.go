@@ -0,0 +1,323 @@
+package circuit
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/test"
+	gnarkNimue "github.com/reilabs/gnark-nimue"
+	skyscraper "github.com/reilabs/gnark-skyscraper"
+)
+
+// chainedSumcheckCircuit runs two single-round WHIR sumchecks over Transcript, the
+// second starting from the claim the first reduces to.
+type chainedSumcheckCircuit struct {
+	Claim      frontend.Variable
+	Transcript []uints.U8
+}
+
+var chainedSumcheckIO = []byte("whir\x00A3sumcheck_poly\x00S1folding_randomness\x00A3sumcheck_poly\x00S1folding_randomness\x00")
+
+func (c *chainedSumcheckCircuit) Define(api frontend.API) error {
+	arthur, err := gnarkNimue.NewSkyscraperArthur(api, skyscraper.NewSkyscraper(api, 2), chainedSumcheckIO, c.Transcript, true)
+	if err != nil {
+		return err
+	}
+	claim := c.Claim
+	for range 2 {
+		_, claimedSum, lastEval, err := runWhirSumcheckRounds(api, arthur, 1, 2, 2, "")
+		if err != nil {
+			return err
+		}
+		assertClaimChained(api, claim, claimedSum)
+		claim = nextRoundClaim(api, lastEval, nil, nil, nil)
+	}
+	return nil
+}
+
+// constantRounds encodes round polynomials, each constant and so evaluating to the
+// same value at any challenge, three evaluations per round.
+func constantRounds(values ...int64) []uints.U8 {
+	var transcript []uints.U8
+	for _, value := range values {
+		word := make([]byte, 32)
+		big.NewInt(value).FillBytes(word)
+		for range 3 {
+			for i := range word {
+				// Scalars are read little endian.
+				transcript = append(transcript, uints.NewU8(word[len(word)-1-i]))
+			}
+		}
+	}
+	return transcript
+}
+
+func TestWhirRoundsAreChained(t *testing.T) {
+	// The first round polynomial is 6 everywhere, so it sums to 12 and reduces to 6;
+	// the second, 3 everywhere, sums to that.
+	honest := &chainedSumcheckCircuit{Claim: 12, Transcript: constantRounds(6, 3)}
+	circuit := &chainedSumcheckCircuit{Transcript: make([]uints.U8, len(honest.Transcript))}
+	if err := test.IsSolved(circuit, honest, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("chained rounds rejected: %v", err)
+	}
+
+	for _, tampered := range []*chainedSumcheckCircuit{
+		// The first round does not start from the claim.
+		{Claim: 10, Transcript: constantRounds(6, 3)},
+		// The second round resets the claim to 8.
+		{Claim: 12, Transcript: constantRounds(6, 4)},
+	} {
+		if err := test.IsSolved(circuit, tampered, ecc.BN254.ScalarField()); err == nil {
+			t.Errorf("claim %v with transcript of %d bytes accepted", tampered.Claim, len(tampered.Transcript))
+		}
+	}
+}
+
+// oodPointsCircuit squeezes len(Expected) OOD points with the hash-to-field Method
+// from the probe transcript Transcript and checks them against Expected.
+type oodPointsCircuit struct {
+	Method     string
+	Transcript []uints.U8
+	Expected   []frontend.Variable
+}
+
+func (c *oodPointsCircuit) Define(api frontend.API) error {
+	arthur, err := gnarkNimue.NewSkyscraperArthur(api, skyscraper.NewSkyscraper(api, 2), challengeProbeIO, c.Transcript, true)
+	if err != nil {
+		return err
+	}
+	if err := arthur.FillNextScalars(make([]frontend.Variable, 1)); err != nil {
+		return err
+	}
+	points := make([]frontend.Variable, len(c.Expected))
+	if err := squeezeFieldElements(api, arthur, c.Method, points); err != nil {
+		return err
+	}
+	for i := range points {
+		api.AssertIsEqual(points[i], c.Expected[i])
+	}
+	return nil
+}
+
+func TestSqueezeFieldElements(t *testing.T) {
+	transcript := seedTranscript(161803)
+	scalars := squeezedScalars(t, challengeProbeIO, challengeProbeScalars, transcript)
+
+	// Native points are the squeezed scalars; byte points reduce the big-endian
+	// value of (254+128)/8 = 47 squeezed bytes, which take 4 scalars each.
+	native := []frontend.Variable{scalars[0], scalars[1]}
+	fromBytes := []frontend.Variable{
+		modularReductionNative(challengeDigest(scalars[0:4], 47)),
+		modularReductionNative(challengeDigest(scalars[4:8], 47)),
+	}
+	shape := func(method string) *oodPointsCircuit {
+		return &oodPointsCircuit{Method: method, Transcript: make([]uints.U8, len(transcript)), Expected: make([]frontend.Variable, 2)}
+	}
+	for _, tc := range []struct {
+		name     string
+		method   string
+		expected []frontend.Variable
+		valid    bool
+	}{
+		{"native", nativeHashToField, native, true},
+		{"default", "", native, true},
+		{"bytes", bytesHashToField, fromBytes, true},
+		{"native against byte points", nativeHashToField, fromBytes, false},
+		{"bytes against native points", bytesHashToField, native, false},
+	} {
+		assignment := &oodPointsCircuit{Method: tc.method, Transcript: transcript, Expected: tc.expected}
+		err := test.IsSolved(shape(tc.method), assignment, ecc.BN254.ScalarField())
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: IsSolved returned %v", tc.name, err)
+		}
+	}
+
+	unknown := &oodPointsCircuit{Method: "xof", Transcript: transcript, Expected: native}
+	if err := test.IsSolved(shape("xof"), unknown, ecc.BN254.ScalarField()); err == nil {
+		t.Error("unknown hash-to-field method accepted")
+	}
+}
+
+// compressProbeCircuit compresses every pair in Pairs with Skyscraper and copies
+// the hashes to hashes through a hint.
+type compressProbeCircuit struct {
+	Pairs  [][2]frontend.Variable
+	hashes *[]*big.Int
+}
+
+func (c *compressProbeCircuit) Define(api frontend.API) error {
+	sc := skyscraper.NewSkyscraper(api, 2)
+	hashes := make([]frontend.Variable, len(c.Pairs))
+	for i, pair := range c.Pairs {
+		hashes[i] = sc.CompressV2(pair[0], pair[1])
+	}
+	_, err := api.Compiler().NewHint(func(_ *big.Int, inputs []*big.Int, _ []*big.Int) error {
+		*c.hashes = (*c.hashes)[:0]
+		for _, value := range inputs {
+			*c.hashes = append(*c.hashes, new(big.Int).Set(value))
+		}
+		return nil
+	}, 1, hashes...)
+	return err
+}
+
+// compressPairs returns the Skyscraper compression of every consecutive pair of
+// nodes, the level of a Merkle tree above nodes.
+func compressPairs(t *testing.T, nodes []*big.Int) []*big.Int {
+	t.Helper()
+	var hashes []*big.Int
+	shape := &compressProbeCircuit{Pairs: make([][2]frontend.Variable, len(nodes)/2), hashes: &hashes}
+	assignment := &compressProbeCircuit{Pairs: make([][2]frontend.Variable, len(nodes)/2), hashes: &hashes}
+	for i := range assignment.Pairs {
+		assignment.Pairs[i] = [2]frontend.Variable{nodes[2*i], nodes[2*i+1]}
+	}
+	if err := test.IsSolved(shape, assignment, ecc.BN254.ScalarField()); err != nil {
+		t.Fatalf("compression probe failed: %v", err)
+	}
+	return hashes
+}
+
+// merkleTree returns the levels of the Merkle tree over eight leaves of two values
+// each, from the leaf hashes up to the root, together with the leaves.
+func merkleTree(t *testing.T) ([][]*big.Int, [][]frontend.Variable) {
+	t.Helper()
+	var values []*big.Int
+	leaves := make([][]frontend.Variable, 8)
+	for k := range leaves {
+		values = append(values, big.NewInt(int64(k+1)), big.NewInt(int64(k+10)))
+		leaves[k] = []frontend.Variable{k + 1, k + 10}
+	}
+	levels := [][]*big.Int{compressPairs(t, values)}
+	for len(levels[len(levels)-1]) > 1 {
+		levels = append(levels, compressPairs(t, levels[len(levels)-1]))
+	}
+	return levels, leaves
+}
+
+// merkleOpeningCircuit checks the opening of Leaf at LeafIndex against Cap.
+type merkleOpeningCircuit struct {
+	LeafIndex   uints.U64
+	Leaf        []frontend.Variable
+	SiblingHash frontend.Variable
+	AuthPath    []frontend.Variable
+	Cap         []frontend.Variable
+}
+
+func (c *merkleOpeningCircuit) Define(api frontend.API) error {
+	uapi, err := uints.New[uints.U64](api)
+	if err != nil {
+		return err
+	}
+	return verifyMerkleTreeProofs(api, uapi, skyscraper.NewSkyscraper(api, 2), []uints.U64{c.LeafIndex}, [][]frontend.Variable{c.Leaf}, []frontend.Variable{c.SiblingHash}, [][]frontend.Variable{c.AuthPath}, c.Cap)
+}
+
+// checkOpening reports whether the opening of leaf at index with the given sibling
+// hash and auth path is accepted against merkleCap.
+func checkOpening(index uint64, leaf []frontend.Variable, sibling *big.Int, authPath []*big.Int, merkleCap []*big.Int) error {
+	assignment := &merkleOpeningCircuit{LeafIndex: uints.NewU64(index), Leaf: leaf, SiblingHash: sibling}
+	for _, node := range authPath {
+		assignment.AuthPath = append(assignment.AuthPath, node)
+	}
+	for _, node := range merkleCap {
+		assignment.Cap = append(assignment.Cap, node)
+	}
+	shape := &merkleOpeningCircuit{Leaf: make([]frontend.Variable, len(leaf)), AuthPath: make([]frontend.Variable, len(authPath)), Cap: make([]frontend.Variable, len(merkleCap))}
+	return test.IsSolved(shape, assignment, ecc.BN254.ScalarField())
+}
+
+func TestVerifyMerkleTreeProofsAgainstACap(t *testing.T) {
+	levels, leaves := merkleTree(t)
+	// Leaf 5 = 0b101 pairs with leaf 4, their parent with the node above leaves 6
+	// and 7, and the top bit puts all of them in the right half of the tree.
+	sibling, parentSibling := levels[0][4], levels[1][3]
+
+	if err := checkOpening(5, leaves[5], sibling, []*big.Int{parentSibling, levels[2][0]}, levels[3]); err != nil {
+		t.Fatalf("opening against the root rejected: %v", err)
+	}
+	// With a cap of height 1 the path stops one level lower, at the cap node the
+	// top bit of the index selects.
+	if err := checkOpening(5, leaves[5], sibling, []*big.Int{parentSibling}, levels[2]); err != nil {
+		t.Fatalf("opening against the cap rejected: %v", err)
+	}
+	swapped := []*big.Int{levels[2][1], levels[2][0]}
+	if err := checkOpening(5, leaves[5], sibling, []*big.Int{parentSibling}, swapped); err == nil {
+		t.Error("opening against the other cap node accepted")
+	}
+	if err := checkOpening(1, leaves[5], sibling, []*big.Int{parentSibling}, levels[2]); err == nil {
+		t.Error("opening at an index in the other half of the tree accepted")
+	}
+	if err := checkOpening(5, leaves[5], sibling, nil, []*big.Int{levels[2][0], levels[2][1], levels[2][0]}); err == nil {
+		t.Error("cap of three nodes accepted")
+	}
+}
+
+func TestCheckFinalEvaluation(t *testing.T) {
+	outputs := []*big.Int{new(big.Int)}
+	if err := checkFinalEvaluation(nil, []*big.Int{big.NewInt(42), big.NewInt(42)}, outputs); err != nil || outputs[0].Int64() != 42 {
+		t.Fatalf("matching evaluations: output %v, error %v", outputs[0], err)
+	}
+
+	err := checkFinalEvaluation(nil, []*big.Int{big.NewInt(41), big.NewInt(42)}, []*big.Int{new(big.Int)})
+	if !errors.Is(err, ErrFinalEvaluationMismatch) || !strings.Contains(err.Error(), "expected 42, got 41") {
+		t.Fatalf("mismatch returned %v", err)
+	}
+	// NativeVerify surfaces the values through the solver.
+	err = solveNative(&finalEvaluationCircuit{Report: true, Actual: 41, Expected: 42})
+	if err == nil || !strings.Contains(err.Error(), "expected 42, got 41") {
+		t.Fatalf("solver dropped the mismatched values: %v", err)
+	}
+}
+
+func TestWHIRConfigMerkleCapSize(t *testing.T) {
+	for _, tc := range []struct {
+		capHeight, shards, size int
+	}{
+		{0, 0, 1},
+		{3, 0, 8},
+		{0, 4, 4},
+		{2, 4, 4},
+	} {
+		cfg := WHIRConfig{MerkleCapHeight: tc.capHeight, NumShards: tc.shards}
+		if size := cfg.merkleCapSize(); size != tc.size {
+			t.Errorf("merkle_cap_height %d, num_shards %d: merkleCapSize = %d, expected %d", tc.capHeight, tc.shards, size, tc.size)
+		}
+	}
+}
+
+func TestVerifyMerkleTreeProofsAgainstAForest(t *testing.T) {
+	// A forest of four shards of two leaves each commits to the shard roots, the
+	// nodes one level above the leaf hashes. Leaf 5 = 0b101 is the second leaf of
+	// shard 2.
+	levels, leaves := merkleTree(t)
+	cfg := WHIRConfig{NumShards: 4}
+	shardRoots := levels[1]
+	if len(shardRoots) != cfg.merkleCapSize() {
+		t.Fatalf("%d shard roots for a cap of %d", len(shardRoots), cfg.merkleCapSize())
+	}
+	if err := checkOpening(5, leaves[5], levels[0][4], nil, shardRoots); err != nil {
+		t.Fatalf("opening of shard 2 rejected: %v", err)
+	}
+	swapped := []*big.Int{shardRoots[0], shardRoots[1], shardRoots[3], shardRoots[2]}
+	if err := checkOpening(5, leaves[5], levels[0][4], nil, swapped); err == nil {
+		t.Error("opening against swapped shard roots accepted")
+	}
+}
+
+func TestCheckPointDimension(t *testing.T) {
+	// The initial fold and one round fold 4 variables each and leave no final
+	// sumcheck rounds, so the point has a coordinate for each of the 8 variables.
+	params := NewWhirParams(validWHIRConfig())
+	if err := checkPointDimension(make([]frontend.Variable, 8), params); err != nil {
+		t.Fatalf("point over every variable rejected: %v", err)
+	}
+	for _, coordinates := range []int{4, 7, 9, 12} {
+		if err := checkPointDimension(make([]frontend.Variable, coordinates), params); !errors.Is(err, ErrPointDimensionMismatch) {
+			t.Errorf("point of %d coordinates: checkPointDimension returned %v", coordinates, err)
+		}
+	}
+}
@@ -27,6 +27,15 @@ func LimbsToBigIntMod(limbs [4]uint64) *big.Int {
 	modulus := new(big.Int)
 	modulus.SetString("21888242871839275222246405745257275088548364400416034343698204186575808495617", 10)
 
+	result := LimbsToBigInt(limbs)
+	result.Mod(result, modulus)
+
+	return result
+}
+
+// LimbsToBigInt assembles little-endian 64-bit limbs into an integer without
+// reducing it.
+func LimbsToBigInt(limbs [4]uint64) *big.Int {
 	result := new(big.Int).SetUint64(limbs[0])
 
 	temp := new(big.Int).SetUint64(limbs[1])
@@ -38,8 +47,6 @@ func LimbsToBigIntMod(limbs [4]uint64) *big.Int {
 	temp.SetUint64(limbs[3])
 	result.Add(result, temp.Lsh(temp, 192))
 
-	result.Mod(result, modulus)
-
 	return result
 }
 
@@ -8,6 +8,7 @@ import (
 	"github.com/consensys/gnark/frontend"
 	"github.com/consensys/gnark/std/lookup/logderivlookup"
 	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/std/rangecheck"
 	gnarkNimue "github.com/reilabs/gnark-nimue"
 	skyscraper "github.com/reilabs/gnark-skyscraper"
 )
@@ -21,6 +22,17 @@ func MultivarPoly(coefs []frontend.Variable, vars []frontend.Variable, api front
 	return api.Add(deg_zero, deg_one)
 }
 
+// VerifyFold asserts that folding preserves the evaluation claim: for a multilinear
+// polynomial p with coefficients parentEvals, folded at r into p'(Y) = p(r, Y),
+// p(r, point) must equal foldedEval. The folded variable is the first one, matching
+// the order in which MultivarPoly consumes folding randomness.
+func VerifyFold(api frontend.API, parentEvals []frontend.Variable, r frontend.Variable, foldedEval frontend.Variable, point []frontend.Variable) {
+	vars := make([]frontend.Variable, 0, len(point)+1)
+	vars = append(vars, r)
+	vars = append(vars, point...)
+	api.AssertIsEqual(MultivarPoly(parentEvals, vars, api), foldedEval)
+}
+
 func UnivarPoly(api frontend.API, coefficients []frontend.Variable, points []frontend.Variable) []frontend.Variable {
 	if len(points) == 0 {
 		return coefficients
@@ -37,6 +49,21 @@ func UnivarPoly(api frontend.API, coefficients []frontend.Variable, points []fro
 	return results
 }
 
+// VerifySumcheckRound checks one sumcheck round whose polynomial p is sent in
+// coefficient form, lowest degree first, and returns p(challenge), the claim for the
+// next round. The degree of p is len(coefficients)-1, so a degree-3 Spartan round
+// (eq(tau, x) * (Az(x) * Bz(x) - Cz(x))) takes four coefficients. WHIR rounds are
+// sent in evaluation form instead and go through CheckSumOverBool. The round is
+// accepted when p(0) + p(1) equals claim.
+func VerifySumcheckRound(api frontend.API, coefficients []frontend.Variable, claim frontend.Variable, challenge frontend.Variable) frontend.Variable {
+	sum := api.Add(
+		UnivarPoly(api, coefficients, []frontend.Variable{0})[0],
+		UnivarPoly(api, coefficients, []frontend.Variable{1})[0],
+	)
+	api.AssertIsEqual(sum, claim)
+	return UnivarPoly(api, coefficients, []frontend.Variable{challenge})[0]
+}
+
 func IndexOf(_ *big.Int, inputs []*big.Int, outputs []*big.Int) error {
 	if len(outputs) != 1 {
 		return fmt.Errorf("expecting one output")
@@ -100,7 +127,22 @@ func PoW(api frontend.API, sc *skyscraper.Skyscraper, arthur gnarkNimue.Arthur,
 	return challenge, nonce, nil
 }
 
+// MaxPoWDifficulty is the largest number of proof-of-work bits CheckPoW can enforce.
+const MaxPoWDifficulty = 27
+
+// PoWNonceBits is the width of the proof-of-work nonce, which the prover sends as
+// eight bytes.
+const PoWNonceBits = 64
+
+// CheckPoW asserts that the nonce fits in PoWNonceBits and that compressing it with
+// the challenge gives a hash with at least difficulty leading zero bits. Without
+// the range check the nonce could be any field element, widening the grinding
+// space beyond what the difficulty accounts for.
 func CheckPoW(api frontend.API, sc *skyscraper.Skyscraper, challenge frontend.Variable, nonce frontend.Variable, difficulty int) error {
+	if difficulty < 0 || difficulty > MaxPoWDifficulty {
+		return fmt.Errorf("unsupported proof-of-work difficulty %d, expected at most %d", difficulty, MaxPoWDifficulty)
+	}
+	rangecheck.New(api).Check(nonce, PoWNonceBits)
 	hash := sc.CompressV2(challenge, nonce)
 
 	d0, _ := new(big.Int).SetString("21888242871839275222246405745257275088548364400416034343698204186575808495617", 10)
@@ -153,6 +195,50 @@ func EvaluateQuadraticPolynomialFromEvaluationList(api frontend.API, evaluations
 	return api.Add(api.Mul(point, point, b2), api.Mul(point, b1), b0)
 }
 
+// EvaluatePolynomialFromEvaluationList evaluates, at point, the polynomial of degree
+// len(evaluations)-1 given by its evaluations at 0, 1, ..., len(evaluations)-1.
+func EvaluatePolynomialFromEvaluationList(api frontend.API, evaluations []frontend.Variable, point frontend.Variable) frontend.Variable {
+	if len(evaluations) == 3 {
+		return EvaluateQuadraticPolynomialFromEvaluationList(api, evaluations, point)
+	}
+
+	ans := frontend.Variable(0)
+	for i := range evaluations {
+		numerator := frontend.Variable(1)
+		denominator := big.NewInt(1)
+		for j := range evaluations {
+			if j == i {
+				continue
+			}
+			numerator = api.Mul(numerator, api.Sub(point, j))
+			denominator.Mul(denominator, big.NewInt(int64(i-j)))
+		}
+		ans = api.Add(ans, api.Mul(evaluations[i], api.Div(numerator, denominator)))
+	}
+	return ans
+}
+
+// AssertDegreeAtMost asserts that the polynomial given by its evaluations at 0, 1,
+// ..., len(evaluations)-1 has degree at most degree, by asserting that all of its
+// finite differences of order degree+1 vanish.
+func AssertDegreeAtMost(api frontend.API, evaluations []frontend.Variable, degree int) {
+	order := degree + 1
+	for start := 0; start+order < len(evaluations); start++ {
+		difference := frontend.Variable(0)
+		binomial := big.NewInt(1)
+		for j := 0; j <= order; j++ {
+			term := api.Mul(evaluations[start+j], binomial)
+			if (order-j)%2 == 0 {
+				difference = api.Add(difference, term)
+			} else {
+				difference = api.Sub(difference, term)
+			}
+			binomial = new(big.Int).Div(new(big.Int).Mul(binomial, big.NewInt(int64(order-j))), big.NewInt(int64(j+1)))
+		}
+		api.AssertIsEqual(difference, 0)
+	}
+}
+
 func Exponent(api frontend.API, uapi *uints.BinaryField[uints.U64], X frontend.Variable, Y uints.U64) frontend.Variable {
 	output := frontend.Variable(1)
 	bits := api.ToBinary(uapi.ToValue(Y))
@@ -210,6 +296,55 @@ func IsSubset(api frontend.API, uapi *uints.BinaryField[uints.U64], arthur gnark
 	return nil
 }
 
+// AssertOpensQueries asserts that merkleIndexes are exactly the distinct values of
+// the query indexes squeezed from the transcript: every query is opened, every
+// opened leaf was queried, and no leaf is opened twice. Colliding queries are opened
+// once, so the number of openings must equal the number of distinct queries, and a
+// proof with missing or extra openings does not satisfy the circuit.
+func AssertOpensQueries(api frontend.API, uapi *uints.BinaryField[uints.U64], arthur gnarkNimue.Arthur, indexes []frontend.Variable, merkleIndexes []uints.U64) error {
+	if err := IsSubset(api, uapi, arthur, indexes, merkleIndexes); err != nil {
+		return err
+	}
+
+	opened := make([]frontend.Variable, len(merkleIndexes))
+	for j, index := range merkleIndexes {
+		opened[j] = uapi.ToValue(index)
+	}
+	queriedLUT := logderivlookup.New(api)
+	inputArr := make([]frontend.Variable, len(indexes)+1)
+	for j, x := range indexes {
+		queriedLUT.Insert(x)
+		inputArr[1+j] = x
+	}
+	for _, x := range opened {
+		inputArr[0] = x
+		res, err := api.Compiler().NewHint(IndexOf, 1, inputArr...)
+		if err != nil {
+			return err
+		}
+		searchRes := queriedLUT.Lookup(res[0])
+		api.AssertIsEqual(x, searchRes[0])
+	}
+	AssertDistinct(api, opened)
+	return nil
+}
+
+// AssertDistinct asserts that the given points are pairwise distinct by checking
+// that the product of all pairwise differences is non-zero, which costs a single
+// inverse instead of one per pair.
+func AssertDistinct(api frontend.API, points []frontend.Variable) {
+	if len(points) < 2 {
+		return
+	}
+	product := frontend.Variable(1)
+	for i := range points {
+		for j := i + 1; j < len(points); j++ {
+			product = api.Mul(product, api.Sub(points[i], points[j]))
+		}
+	}
+	api.AssertIsDifferent(product, 0)
+}
+
 func DotProduct(api frontend.API, a []frontend.Variable, b []frontend.Variable) frontend.Variable {
 	var acc = frontend.Variable(0)
 	for i := range a {
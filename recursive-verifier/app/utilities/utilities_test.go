@@ -0,0 +1,261 @@
+package utilities
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/math/uints"
+	"github.com/consensys/gnark/test"
+	skyscraper "github.com/reilabs/gnark-skyscraper"
+)
+
+var field = ecc.BN254.ScalarField()
+
+// checkSolved asserts that honest satisfies circuit and tampered does not.
+func checkSolved(t *testing.T, circuit frontend.Circuit, honest frontend.Circuit, tampered frontend.Circuit) {
+	t.Helper()
+	if err := test.IsSolved(circuit, honest, field); err != nil {
+		t.Fatalf("honest assignment rejected: %v", err)
+	}
+	if err := test.IsSolved(circuit, tampered, field); err == nil {
+		t.Fatal("tampered assignment accepted")
+	}
+}
+
+type sumcheckRoundCircuit struct {
+	Coefficients []frontend.Variable
+	Claim        frontend.Variable
+	Challenge    frontend.Variable
+	Next         frontend.Variable
+}
+
+func (c *sumcheckRoundCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(VerifySumcheckRound(api, c.Coefficients, c.Claim, c.Challenge), c.Next)
+	return nil
+}
+
+func TestVerifySumcheckRound(t *testing.T) {
+	// p(x) = 1 + 2x + 3x^2 + 4x^3: p(0) + p(1) = 1 + 10 = 11 and p(2) = 49.
+	honest := func() *sumcheckRoundCircuit {
+		return &sumcheckRoundCircuit{
+			Coefficients: []frontend.Variable{1, 2, 3, 4},
+			Claim:        11,
+			Challenge:    2,
+			Next:         49,
+		}
+	}
+	circuit := &sumcheckRoundCircuit{Coefficients: make([]frontend.Variable, 4)}
+
+	wrongClaim := honest()
+	wrongClaim.Claim = 12
+	checkSolved(t, circuit, honest(), wrongClaim)
+
+	wrongCoefficient := honest()
+	wrongCoefficient.Coefficients[3] = 5
+	checkSolved(t, circuit, honest(), wrongCoefficient)
+}
+
+type foldCircuit struct {
+	ParentEvals []frontend.Variable
+	R           frontend.Variable
+	FoldedEval  frontend.Variable
+	Point       []frontend.Variable
+}
+
+func (c *foldCircuit) Define(api frontend.API) error {
+	VerifyFold(api, c.ParentEvals, c.R, c.FoldedEval, c.Point)
+	return nil
+}
+
+func TestVerifyFold(t *testing.T) {
+	// p(x, y) = 1 + 2x + 3y + 4xy folded at x = 5 and evaluated at y = 7.
+	honest := &foldCircuit{
+		ParentEvals: []frontend.Variable{1, 2, 3, 4},
+		R:           5,
+		FoldedEval:  172,
+		Point:       []frontend.Variable{7},
+	}
+	tampered := *honest
+	tampered.FoldedEval = 173
+	shape := &foldCircuit{ParentEvals: make([]frontend.Variable, 4), Point: make([]frontend.Variable, 1)}
+	checkSolved(t, shape, honest, &tampered)
+
+	// Folding the second variable instead gives 1 + 14 + 15 + 140 = 170.
+	swapped := *honest
+	swapped.FoldedEval = 170
+	checkSolved(t, shape, honest, &swapped)
+}
+
+type evaluationListCircuit struct {
+	Evaluations []frontend.Variable
+	Point       frontend.Variable
+	Value       frontend.Variable
+}
+
+func (c *evaluationListCircuit) Define(api frontend.API) error {
+	api.AssertIsEqual(EvaluatePolynomialFromEvaluationList(api, c.Evaluations, c.Point), c.Value)
+	return nil
+}
+
+func TestEvaluatePolynomialFromEvaluationList(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		evaluations []frontend.Variable
+		point       int
+		value       int
+	}{
+		// x^2 + 1 at 0, 1, 2, and at 7.
+		{"quadratic", []frontend.Variable{1, 2, 5}, 7, 50},
+		// x^3 + 2x + 1 at 0, 1, 2, 3, and at 5.
+		{"cubic", []frontend.Variable{1, 4, 13, 34}, 5, 136},
+	} {
+		shape := &evaluationListCircuit{Evaluations: make([]frontend.Variable, len(tc.evaluations))}
+		honest := &evaluationListCircuit{Evaluations: tc.evaluations, Point: tc.point, Value: tc.value}
+		tampered := &evaluationListCircuit{Evaluations: tc.evaluations, Point: tc.point, Value: tc.value + 1}
+		t.Run(tc.name, func(t *testing.T) {
+			checkSolved(t, shape, honest, tampered)
+		})
+	}
+}
+
+type distinctCircuit struct {
+	Points []frontend.Variable
+}
+
+func (c *distinctCircuit) Define(api frontend.API) error {
+	AssertDistinct(api, c.Points)
+	return nil
+}
+
+func TestAssertDistinct(t *testing.T) {
+	shape := &distinctCircuit{Points: make([]frontend.Variable, 4)}
+	honest := &distinctCircuit{Points: []frontend.Variable{3, 1, 4, 5}}
+	for _, repeated := range [][]frontend.Variable{
+		{3, 1, 4, 3},
+		{3, 1, 1, 5},
+	} {
+		checkSolved(t, shape, honest, &distinctCircuit{Points: repeated})
+	}
+}
+
+type opensQueriesCircuit struct {
+	Queries []frontend.Variable
+	Opened  []uints.U64
+}
+
+func (c *opensQueriesCircuit) Define(api frontend.API) error {
+	uapi, err := uints.New[uints.U64](api)
+	if err != nil {
+		return err
+	}
+	return AssertOpensQueries(api, uapi, nil, c.Queries, c.Opened)
+}
+
+func TestAssertOpensQueries(t *testing.T) {
+	queries := []frontend.Variable{5, 3, 5}
+	opened := func(indexes ...uint64) *opensQueriesCircuit {
+		c := &opensQueriesCircuit{Queries: queries}
+		for _, index := range indexes {
+			c.Opened = append(c.Opened, uints.NewU64(index))
+		}
+		return c
+	}
+	// The colliding query 5 is opened once.
+	honest := opened(3, 5)
+	shape := &opensQueriesCircuit{Queries: make([]frontend.Variable, 3), Opened: make([]uints.U64, 2)}
+	checkSolved(t, shape, honest, opened(3, 4))
+
+	short := &opensQueriesCircuit{Queries: make([]frontend.Variable, 3), Opened: make([]uints.U64, 1)}
+	if err := test.IsSolved(short, opened(3), field); err == nil {
+		t.Error("openings missing a query accepted")
+	}
+	long := &opensQueriesCircuit{Queries: make([]frontend.Variable, 3), Opened: make([]uints.U64, 3)}
+	for _, tampered := range []*opensQueriesCircuit{opened(3, 5, 7), opened(3, 5, 5)} {
+		if err := test.IsSolved(long, tampered, field); err == nil {
+			t.Errorf("openings %v of queries %v accepted", tampered.Opened, queries)
+		}
+	}
+	if err := test.IsSolved(long, &opensQueriesCircuit{Queries: []frontend.Variable{5, 3, 7}, Opened: opened(3, 5, 7).Opened}, field); err != nil {
+		t.Errorf("openings of three distinct queries rejected: %v", err)
+	}
+}
+
+type degreeCircuit struct {
+	Degree      int
+	Evaluations []frontend.Variable
+}
+
+func (c *degreeCircuit) Define(api frontend.API) error {
+	AssertDegreeAtMost(api, c.Evaluations, c.Degree)
+	return nil
+}
+
+func TestAssertDegreeAtMost(t *testing.T) {
+	// x^2 + 1 at 0, 1, 2 and 3.
+	square := []frontend.Variable{1, 2, 5, 10}
+
+	shape := &degreeCircuit{Degree: 2, Evaluations: make([]frontend.Variable, 4)}
+	checkSolved(t, shape, &degreeCircuit{Degree: 2, Evaluations: square}, &degreeCircuit{Degree: 2, Evaluations: []frontend.Variable{1, 2, 5, 11}})
+
+	linear := &degreeCircuit{Degree: 1, Evaluations: make([]frontend.Variable, 4)}
+	checkSolved(t, linear, &degreeCircuit{Degree: 1, Evaluations: []frontend.Variable{1, 3, 5, 7}}, &degreeCircuit{Degree: 1, Evaluations: square})
+}
+
+// compressProbeCircuit copies the Skyscraper compression of Left and Right to hash
+// through a hint, so a test can pick a proof-of-work difficulty it meets.
+type compressProbeCircuit struct {
+	Left, Right frontend.Variable
+	hash        *big.Int
+}
+
+func (c *compressProbeCircuit) Define(api frontend.API) error {
+	compressed := skyscraper.NewSkyscraper(api, 2).CompressV2(c.Left, c.Right)
+	_, err := api.Compiler().NewHint(func(_ *big.Int, inputs []*big.Int, _ []*big.Int) error {
+		c.hash.Set(inputs[0])
+		return nil
+	}, 1, compressed)
+	return err
+}
+
+type powCircuit struct {
+	Difficulty int
+	Challenge  frontend.Variable
+	Nonce      frontend.Variable
+}
+
+func (c *powCircuit) Define(api frontend.API) error {
+	return CheckPoW(api, skyscraper.NewSkyscraper(api, 2), c.Challenge, c.Nonce, c.Difficulty)
+}
+
+func TestCheckPoW(t *testing.T) {
+	const challenge, nonce = 12345, 678
+	hash := new(big.Int)
+	probe := &compressProbeCircuit{hash: hash}
+	if err := test.IsSolved(probe, &compressProbeCircuit{Left: challenge, Right: nonce, hash: hash}, field); err != nil {
+		t.Fatal(err)
+	}
+	// The hash meets difficulty d when it is at most the modulus shifted right by d.
+	met := 0
+	for met < MaxPoWDifficulty && hash.Cmp(new(big.Int).Rsh(field, uint(met+1))) <= 0 {
+		met++
+	}
+	if met == MaxPoWDifficulty {
+		t.Fatalf("hash %s meets every difficulty", hash)
+	}
+
+	shape := &powCircuit{Difficulty: met}
+	if err := test.IsSolved(shape, &powCircuit{Difficulty: met, Challenge: challenge, Nonce: nonce}, field); err != nil {
+		t.Fatalf("nonce meeting difficulty %d rejected: %v", met, err)
+	}
+	harder := &powCircuit{Difficulty: met + 1}
+	if err := test.IsSolved(harder, &powCircuit{Difficulty: met + 1, Challenge: challenge, Nonce: nonce}, field); err == nil {
+		t.Fatalf("nonce accepted for difficulty %d, its hash %s only meets %d", met+1, hash, met)
+	}
+
+	// A nonce wider than PoWNonceBits is rejected even when no difficulty is asked.
+	easy := &powCircuit{Difficulty: 0}
+	wide := new(big.Int).Lsh(big.NewInt(1), PoWNonceBits)
+	checkSolved(t, easy, &powCircuit{Challenge: challenge, Nonce: new(big.Int).Sub(wide, big.NewInt(1))}, &powCircuit{Challenge: challenge, Nonce: wide})
+}